@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// annotationPrefix is the prefix of the annotations read by the notifier.
+const annotationPrefix = "kube-job-notifier/"
+
+// namespaceAnnotationNames are only read from the Namespace. They send
+// notifications to an arbitrary URL, which whoever can create a Job must not
+// be able to choose.
+var namespaceAnnotationNames = []string{notification.MsTeamsV2WebhookURLAnnotationName}
+
+// getAnnotations returns the notifier annotations that apply to the Job. They
+// are merged from the Namespace, the CronJob metadata, the Job metadata and
+// the pod template, in that order, each overriding the previous ones, so that
+// a namespace wide default can be refined per CronJob and per Job, except
+// for namespaceAnnotationNames. job or cronJob may be nil.
+func (c *Controller) getAnnotations(job *batchv1.Job, cronJob *batchv1.CronJob) map[string]string {
+	var namespace string
+	var sources []map[string]string
+	if cronJob != nil {
+		namespace = cronJob.Namespace
+		sources = append(sources, cronJob.Annotations)
+	}
+	if job != nil {
+		namespace = job.Namespace
+		sources = append(sources, job.Annotations, job.Spec.Template.Annotations)
+	}
+	sources = append([]map[string]string{c.getNamespaceAnnotations(namespace)}, sources...)
+
+	annotations := make(map[string]string)
+	for i, source := range sources {
+		for k, v := range source {
+			if !strings.HasPrefix(k, annotationPrefix) || funk.ContainsString(statusAnnotationNames, k) {
+				continue
+			}
+			if i > 0 && funk.ContainsString(namespaceAnnotationNames, k) {
+				continue
+			}
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// newJobFromTemplate returns a Job as the CronJob would create it, for
+// notifications about the CronJob itself.
+func newJobFromTemplate(cronJob *batchv1.CronJob) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: *cronJob.Spec.JobTemplate.ObjectMeta.DeepCopy(),
+		Spec:       *cronJob.Spec.JobTemplate.Spec.DeepCopy(),
+	}
+	job.Namespace = cronJob.Namespace
+	return job
+}
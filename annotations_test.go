@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetAnnotations(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "default",
+		Annotations: map[string]string{
+			"kube-job-notifier/default-channel": "namespace-channel",
+			"kube-job-notifier/failed-channel":  "namespace-failed",
+			"kube-job-notifier/log-mode":        "PodOnly",
+		},
+	}}
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test-cronjob",
+		Namespace: "default",
+		Annotations: map[string]string{
+			"kube-job-notifier/default-channel": "cronjob-channel",
+			"kube-job-notifier/failed-channel":  "cronjob-failed",
+		},
+	}}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-job",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"kube-job-notifier/failed-channel": "job-failed",
+				notifiedEventsAnnotationName:       "start",
+				"example.com/unrelated":            "value",
+			},
+		},
+		Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"kube-job-notifier/suppress-success-notification": "true"},
+		}}},
+	}
+	c, _ := newTestController(t, nil, namespace)
+
+	t.Run("job", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"kube-job-notifier/default-channel":               "cronjob-channel",
+			"kube-job-notifier/failed-channel":                "job-failed",
+			"kube-job-notifier/log-mode":                      "PodOnly",
+			"kube-job-notifier/suppress-success-notification": "true",
+		}, c.getAnnotations(job, cronJob))
+	})
+
+	t.Run("cronjob only", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"kube-job-notifier/default-channel": "cronjob-channel",
+			"kube-job-notifier/failed-channel":  "cronjob-failed",
+			"kube-job-notifier/log-mode":        "PodOnly",
+		}, c.getAnnotations(nil, cronJob))
+	})
+
+	t.Run("without namespace informer", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"kube-job-notifier/failed-channel":                "job-failed",
+			"kube-job-notifier/suppress-success-notification": "true",
+		}, (&Controller{}).getAnnotations(job, nil))
+	})
+}
+
+func TestGetAnnotationsNamespaceOnly(t *testing.T) {
+	const webhookURL = notification.MsTeamsV2WebhookURLAnnotationName
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-job",
+			Namespace:   "default",
+			Annotations: map[string]string{webhookURL: "https://job.example.com"},
+		},
+		Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{webhookURL: "https://pod.example.com"},
+		}}},
+	}
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test-cronjob",
+		Namespace:   "default",
+		Annotations: map[string]string{webhookURL: "https://cronjob.example.com"},
+	}}
+
+	c, _ := newTestController(t, nil)
+	assert.Empty(t, c.getAnnotations(job, cronJob), "Jobs and CronJobs cannot redirect notifications")
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "default",
+		Annotations: map[string]string{webhookURL: "https://namespace.example.com"},
+	}}
+	c, _ = newTestController(t, nil, namespace)
+	assert.Equal(t, map[string]string{webhookURL: "https://namespace.example.com"}, c.getAnnotations(job, cronJob))
+}
+
+func TestNewJobFromTemplate(t *testing.T) {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cronjob", Namespace: "default"},
+		Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"kube-job-notifier/failed-channel": "alerts"}},
+		}},
+	}
+	job := newJobFromTemplate(cronJob)
+	assert.Equal(t, "default", job.Namespace)
+	assert.Equal(t, "alerts", job.Annotations["kube-job-notifier/failed-channel"])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// cluster is a Kubernetes cluster watched by its own informers and
+// controllers. Its name is added to every message about its Jobs.
+type cluster struct {
+	name   string
+	config *rest.Config
+}
+
+// loadClusters returns the clusters of the contexts in the kubeconfig file
+// and of the kubeconfig files in the directory. Clusters are named after the
+// context or after the file without its extension.
+func loadClusters(kubeconfig string, contexts []string, dir string) ([]cluster, error) {
+	var clusters []cluster
+	for _, context := range contexts {
+		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+			&clientcmd.ConfigOverrides{CurrentContext: context},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load context %s: %w", context, err)
+		}
+		clusters = append(clusters, cluster{name: context, config: cfg})
+	}
+
+	if dir == "" {
+		return clusters, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig directory: %w", err)
+	}
+	for _, entry := range entries {
+		// Skip directories and the "..data" links of mounted Secrets.
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		cfg, err := clientcmd.BuildConfigFromFlags("", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", path, err)
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		clusters = append(clusters, cluster{name: name, config: cfg})
+	}
+	return clusters, nil
+}
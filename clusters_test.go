@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+users:
+- name: notifier
+  user:
+    token: secret
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: notifier
+- name: staging
+  context:
+    cluster: staging
+    user: notifier
+current-context: prod
+`
+
+func TestLoadClusters(t *testing.T) {
+	dir := t.TempDir()
+	kubeconfig := filepath.Join(dir, "config")
+	assert.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600))
+
+	t.Run("contexts", func(t *testing.T) {
+		clusters, err := loadClusters(kubeconfig, []string{"prod", "staging"}, "")
+		assert.NoError(t, err)
+		if assert.Len(t, clusters, 2) {
+			assert.Equal(t, "prod", clusters[0].name)
+			assert.Equal(t, "https://prod.example.com", clusters[0].config.Host)
+			assert.Equal(t, "staging", clusters[1].name)
+			assert.Equal(t, "https://staging.example.com", clusters[1].config.Host)
+		}
+	})
+
+	t.Run("unknown context", func(t *testing.T) {
+		_, err := loadClusters(kubeconfig, []string{"dev"}, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		configDir := filepath.Join(dir, "clusters")
+		assert.NoError(t, os.MkdirAll(filepath.Join(configDir, "..data"), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(configDir, "prod-eu.yaml"), []byte(testKubeconfig), 0o600))
+
+		clusters, err := loadClusters(kubeconfig, nil, configDir)
+		assert.NoError(t, err)
+		if assert.Len(t, clusters, 1) {
+			assert.Equal(t, "prod-eu", clusters[0].name)
+			assert.Equal(t, "https://prod.example.com", clusters[0].config.Host)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		clusters, err := loadClusters(kubeconfig, nil, "")
+		assert.NoError(t, err)
+		assert.Empty(t, clusters)
+	})
+}
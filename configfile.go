@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// fileConfig is the config file of the notifier, usually mounted from a
+// ConfigMap. Environment variables override it, so that deployments
+// configured through them keep working and secrets such as SLACK_TOKEN can
+// be read from a Secret.
+type fileConfig struct {
+	notification.Config
+	Datadog monitoring.DatadogConfig `json:"datadog"`
+	Filters filterConfig             `json:"filters"`
+	// Route routes the notifications to the sinks and receivers. Without
+	// it, every enabled sink receives every notification.
+	Route *routeConfig `json:"route"`
+}
+
+// filterConfig selects the Jobs that are notified. Namespaces,
+// ExcludeNamespaces, NamespaceSelector and JobLabelSelector configure the
+// informers, so changes to them take effect on restart only.
+type filterConfig struct {
+	Namespaces        []string `json:"namespaces"`
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	NamespaceSelector string   `json:"namespaceSelector"`
+	JobLabelSelector  string   `json:"jobLabelSelector"`
+	CronJobRegex      string   `json:"cronJobRegex"`
+	CEL               string   `json:"cel"`
+}
+
+// loadConfigFile reads and validates the config file. An empty path only
+// reads the environment variables.
+func loadConfigFile(path string) (*fileConfig, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	return parseConfigFile(data)
+}
+
+func parseConfigFile(data []byte) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	cfg.loadEnv()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *fileConfig) loadEnv() {
+	c.Config.LoadEnv()
+	c.Datadog.LoadEnv()
+	if v, ok := os.LookupEnv("NAMESPACE"); ok {
+		c.Filters.Namespaces = splitList(v)
+	}
+	if v, ok := os.LookupEnv("EXCLUDE_NAMESPACES"); ok {
+		c.Filters.ExcludeNamespaces = splitList(v)
+	}
+	if v, ok := os.LookupEnv("NAMESPACE_SELECTOR"); ok {
+		c.Filters.NamespaceSelector = v
+	}
+	if v, ok := os.LookupEnv("JOB_LABEL_SELECTOR"); ok {
+		c.Filters.JobLabelSelector = v
+	}
+	if v, ok := os.LookupEnv("CRONJOB_REGEX"); ok {
+		c.Filters.CronJobRegex = v
+	}
+	if v, ok := os.LookupEnv("JOB_CEL_FILTER"); ok {
+		c.Filters.CEL = v
+	}
+}
+
+func (c *fileConfig) validate() error {
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	if len(getWatchedNamespaces(c.Filters.Namespaces, c.Filters.ExcludeNamespaces)) == 0 {
+		return fmt.Errorf("filters: every namespace is excluded")
+	}
+	if _, err := labels.Parse(c.Filters.NamespaceSelector); err != nil {
+		return fmt.Errorf("filters.namespaceSelector: %w", err)
+	}
+	if _, err := labels.Parse(c.Filters.JobLabelSelector); err != nil {
+		return fmt.Errorf("filters.jobLabelSelector: %w", err)
+	}
+	if _, err := regexp.Compile(c.Filters.CronJobRegex); err != nil {
+		return fmt.Errorf("filters.cronJobRegex: %w", err)
+	}
+	if c.Filters.CEL != "" {
+		if _, err := newJobFilter(c.Filters.CEL); err != nil {
+			return fmt.Errorf("filters.cel: %w", err)
+		}
+	}
+	if c.Route == nil && len(c.Receivers) > 0 {
+		return fmt.Errorf("receivers: a route is required to send notifications to receivers")
+	}
+	if _, err := c.newRoute(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newRoute compiles the routing tree, which is nil if not configured.
+func (c *fileConfig) newRoute() (*route, error) {
+	if c.Route == nil {
+		return nil, nil
+	}
+	var receivers []string
+	if c.Slack.Enabled {
+		receivers = append(receivers, "slack")
+	}
+	if c.MsTeamsV2.Enabled {
+		receivers = append(receivers, "msteamsv2")
+	}
+	for _, r := range c.Receivers {
+		receivers = append(receivers, r.Name)
+	}
+	return newRoute(*c.Route, receivers)
+}
+
+// informerFilters returns the filters that only take effect on restart.
+func (c *fileConfig) informerFilters() filterConfig {
+	return filterConfig{
+		Namespaces:        c.Filters.Namespaces,
+		ExcludeNamespaces: c.Filters.ExcludeNamespaces,
+		NamespaceSelector: c.Filters.NamespaceSelector,
+		JobLabelSelector:  c.Filters.JobLabelSelector,
+	}
+}
+
+// watchConfigFile checks the config file for changes every interval until
+// stopCh is closed, and passes every valid change to reload. Invalid changes
+// are logged and ignored, so that a typo does not stop notifications.
+func watchConfigFile(path string, interval time.Duration, reload func(*fileConfig), stopCh <-chan struct{}) {
+	last, _ := os.ReadFile(path)
+	wait.Until(func() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Errorf("Failed to read config file %s: %v", path, err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+		cfg, err := parseConfigFile(data)
+		if err != nil {
+			klog.Errorf("Ignoring change of config file %s: %v", path, err)
+			return
+		}
+		klog.Infof("Reloading config file %s", path)
+		reload(cfg)
+	}, interval, stopCh)
+}
+
+// configReloader applies the current config to every registered controller.
+type configReloader struct {
+	mu     sync.Mutex
+	config *fileConfig
+	// compiled is built from config by the first register or reload.
+	compiled    *compiledConfig
+	controllers []*Controller
+}
+
+// register applies the current config to the controller and keeps it
+// up to date with later reloads.
+func (r *configReloader) register(c *Controller) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.compiled == nil {
+		compiled, err := compileConfig(r.config, nil)
+		if err != nil {
+			return err
+		}
+		r.compiled = compiled
+	}
+	c.applyConfig(r.compiled)
+	r.controllers = append(r.controllers, c)
+	return nil
+}
+
+// current returns the current config.
+func (r *configReloader) current() *fileConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// reload applies the config to every registered controller. If it cannot be
+// applied, every controller keeps the current config.
+func (r *configReloader) reload(cfg *fileConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	compiled, err := compileConfig(cfg, r.compiled)
+	if err != nil {
+		klog.Errorf("Failed to apply config, keeping the current one: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(r.config.informerFilters(), cfg.informerFilters()) {
+		klog.Warning("Changes to filters.namespaces, excludeNamespaces, namespaceSelector and jobLabelSelector take effect on restart")
+	}
+	for _, c := range r.controllers {
+		c.applyConfig(compiled)
+	}
+	r.config = cfg
+	r.compiled = compiled
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+slack:
+  enabled: true
+  token: xoxb-from-file
+  channel: C0123456789
+  failedChannel: "#alerts"
+  notify:
+    started: false
+datadog:
+  enabled: true
+  tags: ["team:platform"]
+filters:
+  excludeNamespaces: [kube-system]
+  cronJobRegex: "^nightly-"
+  cel: 'job.metadata.namespace != "sandbox"'
+receivers:
+  - name: hook
+    webhook:
+      url: https://example.com/hook
+route:
+  receiver: hook
+  routes:
+    - match:
+        namespaces: [billing]
+        events: [failure]
+      receiver: slack
+`
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(testConfigFile), 0o600))
+
+	t.Run("reads file", func(t *testing.T) {
+		cfg, err := loadConfigFile(path)
+		assert.NoError(t, err)
+		assert.True(t, cfg.Slack.Enabled)
+		assert.Equal(t, "xoxb-from-file", cfg.Slack.Token)
+		assert.Equal(t, "#alerts", cfg.Slack.FailedChannel)
+		assert.Equal(t, map[string]bool{"started": false}, cfg.Slack.Notify)
+		assert.Equal(t, []string{"team:platform"}, cfg.Datadog.Tags)
+		assert.Equal(t, []string{"kube-system"}, cfg.Filters.ExcludeNamespaces)
+		assert.Equal(t, "^nightly-", cfg.Filters.CronJobRegex)
+		assert.Equal(t, "https://example.com/hook", cfg.Receivers[0].Webhook.URL)
+		assert.Equal(t, "hook", cfg.Route.Receiver)
+		assert.Equal(t, []string{"failure"}, cfg.Route.Routes[0].Match.Events)
+	})
+
+	t.Run("environment variables override file", func(t *testing.T) {
+		t.Setenv("SLACK_TOKEN", "xoxb-from-env")
+		t.Setenv("SLACK_STARTED_NOTIFY", "true")
+		t.Setenv("CRONJOB_REGEX", "")
+		cfg, err := loadConfigFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "xoxb-from-env", cfg.Slack.Token)
+		assert.Equal(t, map[string]bool{"started": true}, cfg.Slack.Notify)
+		assert.Empty(t, cfg.Filters.CronJobRegex)
+	})
+
+	t.Run("environment variables only", func(t *testing.T) {
+		t.Setenv("MSTEAMSV2_ENABLED", "true")
+		t.Setenv("MSTEAMSV2_WEBHOOK_URL", "https://example.com/webhook")
+		cfg, err := loadConfigFile("")
+		assert.NoError(t, err)
+		assert.True(t, cfg.MsTeamsV2.Enabled)
+		assert.False(t, cfg.Slack.Enabled)
+	})
+}
+
+func TestParseConfigFileInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{"unknown field", "slack:\n  chanel: C0123456789\n", "unknown field"},
+		{"missing token", "slack:\n  enabled: true\n", "slack.token is required"},
+		{"unknown event", "slack:\n  notify:\n    finished: false\n", `unknown event "finished"`},
+		{"template", "slack:\n  template: '{{.JobName'\n", "slack.template"},
+		{"selector", "filters:\n  jobLabelSelector: 'team in billing'\n", "filters.jobLabelSelector"},
+		{"regex", "filters:\n  cronJobRegex: '('\n", "filters.cronJobRegex"},
+		{"cel", "filters:\n  cel: 'job.metadata.name'\n", "filters.cel"},
+		{"every namespace excluded", "filters:\n  namespaces: [a]\n  excludeNamespaces: [a]\n", "every namespace is excluded"},
+		{"receivers without route", "receivers:\n  - name: hook\n    webhook:\n      url: https://example.com\n", "a route is required"},
+		{"unknown receiver", "route:\n  receiver: slack\n", `unknown receiver "slack"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseConfigFile([]byte(test.config))
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+}
+
+func TestConfigReloader(t *testing.T) {
+	c, _ := newTestController(t, nil)
+	other, _ := newTestController(t, nil)
+	r := &configReloader{config: &fileConfig{}}
+	assert.NoError(t, r.register(c))
+	assert.NoError(t, r.register(other))
+	regex, filter := c.getFilters()
+	assert.Nil(t, regex)
+	assert.Nil(t, filter)
+
+	cfg, err := parseConfigFile([]byte(testConfigFile))
+	assert.NoError(t, err)
+	cfg.Slack.Enabled = false
+	cfg.Datadog.Enabled = false
+	cfg.Route.Routes = nil
+	r.reload(cfg)
+	regex, filter = c.getFilters()
+	assert.Equal(t, "^nightly-", regex.String())
+	assert.NotNil(t, filter)
+	assert.Equal(t, "hook", c.getRoute().receiver)
+	assert.Same(t, cfg, r.current())
+	notifications, _ := c.getSinks()
+	otherNotifications, _ := other.getSinks()
+	assert.Equal(t, reflect.ValueOf(notifications).Pointer(), reflect.ValueOf(otherNotifications).Pointer(),
+		"the sinks are built once for every controller")
+
+	invalid := *cfg
+	invalid.Filters.CEL = "job.metadata.name =="
+	r.reload(&invalid)
+	assert.Same(t, cfg, r.current(), "a config that cannot be applied is not current")
+	_, filter = other.getFilters()
+	assert.NotNil(t, filter)
+	assert.Equal(t, cfg.Filters.CEL, other.appliedConfig.Filters.CEL)
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("filters:\n  cronJobRegex: '^a'\n"), 0o600))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	reloaded := make(chan *fileConfig, 1)
+	go watchConfigFile(path, 10*time.Millisecond, func(cfg *fileConfig) { reloaded <- cfg }, stopCh)
+
+	// Invalid changes are ignored.
+	assert.NoError(t, os.WriteFile(path, []byte("filters:\n  cronJobRegex: '('\n"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(path, []byte("filters:\n  cronJobRegex: '^b'\n"), 0o600))
+	select {
+	case cfg := <-reloaded:
+		assert.Equal(t, "^b", cfg.Filters.CronJobRegex)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config file was not reloaded")
+	}
+}
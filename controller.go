@@ -3,40 +3,143 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"os"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/thoas/go-funk"
 	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
 	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	"golang.org/x/time/rate"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	batchesinformers "k8s.io/client-go/informers/batch/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	batcheslisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
 const (
 	controllerAgentName = "cronjob-controller"
-	intTrue             = 1
 	searchLabel         = "controller-uid"
+	// jobUIDLabel replaces searchLabel on Pods created by Kubernetes 1.27 and later.
+	jobUIDLabel = "batch.kubernetes.io/controller-uid"
+
+	// jobUIDIndex indexes Pods by the UID of the Job that owns them.
+	jobUIDIndex = "jobUID"
+
+	// datadogSinkName is the name deliveries to Datadog are recorded under.
+	datadogSinkName = "datadog"
+
+	// reasonNotificationSent and reasonNotificationFailed are the reasons of
+	// the Events recorded on a Job for each delivery to a sink.
+	reasonNotificationSent   = "NotificationSent"
+	reasonNotificationFailed = "NotificationFailed"
+
+	// maxRetries is the number of times a Job will be retried before it is
+	// dropped out of the queue. With the backoff of newRateLimiter, the
+	// retries span about an hour, so that a finished Job, which gets no
+	// further updates, is still notified after a sink outage.
+	maxRetries = 20
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff between
+	// retries of a Job.
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+
+	// stuckPodRecheckInterval is how often a Pending Pod is checked for a
+	// stuck reason once the stuck threshold has passed.
+	stuckPodRecheckInterval = time.Minute
+
+	// retryLogLines is the number of trailing log lines of a failed attempt
+	// included in a retry notification.
+	retryLogLines = 20
+
+	// defaultBackoffLimit is used for Jobs without spec.backoffLimit.
+	defaultBackoffLimit = 6
 
 	logModeAnnotationName = "kube-job-notifier/log-mode"
+	// expectedDurationAnnotationName sets the duration after which a running
+	// Job is notified as running longer than expected, e.g. "30m".
+	expectedDurationAnnotationName = "kube-job-notifier/expected-duration"
+	// notifyRetriesAnnotationName opts a Job in to a notification for every
+	// failed attempt that Kubernetes retries.
+	notifyRetriesAnnotationName = "kube-job-notifier/notify-retries"
+	// enabledAnnotationName opts a Job, CronJob or Namespace in to
+	// notifications in opt-in mode.
+	enabledAnnotationName = "kube-job-notifier/enabled"
+
+	// notifiedEventsAnnotationName records on the Job itself which events were
+	// already delivered, so that the state survives restarts of the notifier.
+	notifiedEventsAnnotationName = "kube-job-notifier/notified-events"
+	// notifiedAtAnnotationName, notifiedSinksAnnotationName and
+	// slackPermalinkAnnotationName record on the Job when and where its
+	// notifications were last delivered.
+	notifiedAtAnnotationName     = "kube-job-notifier/notified-at"
+	notifiedSinksAnnotationName  = "kube-job-notifier/notified-sinks"
+	slackPermalinkAnnotationName = "kube-job-notifier/slack-permalink"
+
+	// lastJobAnnotationName, lastResultAnnotationName,
+	// consecutiveFailuresAnnotationName and consecutiveSuccessesAnnotationName
+	// record on the CronJob the result of its latest finished Job and how many
+	// Jobs in a row had that result.
+	lastJobAnnotationName              = "kube-job-notifier/last-job"
+	lastResultAnnotationName           = "kube-job-notifier/last-result"
+	consecutiveFailuresAnnotationName  = "kube-job-notifier/consecutive-failures"
+	consecutiveSuccessesAnnotationName = "kube-job-notifier/consecutive-successes"
+	// missedScheduleAnnotationName records on the CronJob the scheduled time
+	// of the last missed run that was notified, so that it is not notified
+	// again after a restart.
+	missedScheduleAnnotationName = "kube-job-notifier/missed-schedule-notified"
 )
 
+// statusAnnotationNames are the annotations written by the notifier, which
+// are not passed on to notifications.
+var statusAnnotationNames = []string{
+	notifiedEventsAnnotationName,
+	notifiedAtAnnotationName,
+	notifiedSinksAnnotationName,
+	slackPermalinkAnnotationName,
+	lastJobAnnotationName,
+	lastResultAnnotationName,
+	consecutiveFailuresAnnotationName,
+	consecutiveSuccessesAnnotationName,
+	missedScheduleAnnotationName,
+}
+
+// stuckContainerReasons are the waiting reasons of containers that will not
+// start without a change to the Job or the cluster.
+var stuckContainerReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+}
+
 type logMode int
 
 const (
@@ -45,19 +148,119 @@ const (
 	podContainers
 )
 
-var serverStartTime time.Time
-
 // Controller is Kubernetes Controller struct
 type Controller struct {
-	jobsLister batcheslisters.JobLister
-	jobsSynced cache.InformerSynced
-	recorder   record.EventRecorder
+	kubeclientset kubernetes.Interface
+	jobsLister    batcheslisters.JobLister
+	jobsSynced    cache.InformerSynced
+	cronJobLister batcheslisters.CronJobLister
+	cronJobSynced cache.InformerSynced
+	podsIndexer   cache.Indexer
+	podsSynced    cache.InformerSynced
+	// namespaceLister is nil if Namespaces are not watched.
+	namespaceLister  corelisters.NamespaceLister
+	namespacesSynced cache.InformerSynced
+	recorder         record.EventRecorder
+	// informers are the informers of the controller by resource, which are
+	// checked by the liveness probe.
+	informers map[string]cache.SharedIndexInformer
+	// running and synced report to the probes whether Run is in progress and
+	// whether the caches synced, see healthChecker.
+	running atomic.Bool
+	synced  atomic.Bool
+
+	// policyLister is nil unless NotificationPolicies are enabled, see
+	// enablePolicies. policyQueue holds the keys of the policies whose status
+	// is reconciled. secretLister only lists the Secrets labeled with
+	// policySecretLabelName.
+	policyLister   cache.GenericLister
+	policiesSynced cache.InformerSynced
+	secretLister   corelisters.SecretLister
+	secretsSynced  cache.InformerSynced
+	policyClient   dynamic.NamespaceableResourceInterface
+	policyQueue    workqueue.TypedRateLimitingInterface[string]
+
+	// workqueue is a rate limited work queue. Event handlers only enqueue
+	// Job keys here, and workers reconcile them one at a time so that a
+	// slow Job never blocks the delivery of other Job events.
+	workqueue workqueue.TypedRateLimitingInterface[string]
+
+	// configMu guards the sinks and filters below, which are replaced when
+	// the config file is reloaded, see applyConfig.
+	configMu            sync.RWMutex
+	appliedConfig       *fileConfig
+	notifications       map[string]notification.Notification
+	datadogSubscription monitoring.Subscription
+	regex               *regexp.Regexp
+	filter              *jobFilter
+	route               *route
+	notifiedJobs        *notifiedJobs
+	// routeGroups holds back the events of a group of a route, to send
+	// them in one notification, see routeConfig.GroupBy.
+	routeGroups *routeGroups
+
+	// catchUpSince is the oldest event time that is still notified. Events
+	// that happened before it and are not recorded on the Job are skipped, so
+	// that a restart catches up on recent Jobs without notifying the whole
+	// Job history.
+	catchUpSince time.Time
+	// startedAt is when the controller was created. Jobs created before it
+	// are not counted by the Job metrics, see recordJobMetrics.
+	startedAt time.Time
+
+	config controllerConfig
+	// missedSchedules maps the UID of each CronJob currently behind schedule
+	// to the ID its missed schedule was notified with.
+	missedSchedules map[types.UID]string
+}
+
+// controllerConfig holds the tunables of the controller set from flags.
+type controllerConfig struct {
+	// catchUpWindow is how far back Jobs are still notified on startup.
+	catchUpWindow time.Duration
+	// missedScheduleGracePeriod is how late a CronJob run may be before a
+	// missed schedule is notified. Zero disables the check.
+	missedScheduleGracePeriod time.Duration
+	// missedScheduleCheckInterval is how often CronJob schedules are checked.
+	missedScheduleCheckInterval time.Duration
+	// defaultExpectedDuration is used for Jobs without the expected duration
+	// annotation. Zero disables long-running notifications for them.
+	defaultExpectedDuration time.Duration
+	// stuckPodThreshold is how long a Pod may stay Pending for a known reason
+	// before the Job is notified as stuck. Zero disables the notification.
+	stuckPodThreshold time.Duration
+	// failureEvents is the maximum number of Kubernetes Events included in
+	// failure notifications. Zero disables collecting them.
+	failureEvents int
+	// namespaceSelector restricts notifications to namespaces with matching
+	// labels. Nil watches every namespace.
+	namespaceSelector labels.Selector
+	// jobLabelSelector is the label selector of the Job informer, which
+	// CronJobs are checked against by the labels of their job template. Nil
+	// selects every Job.
+	jobLabelSelector labels.Selector
+	// optIn only notifies Jobs, CronJobs and Namespaces with the enabled
+	// annotation set to "true".
+	optIn bool
+	// clusterName is the name of the cluster added to messages, empty when
+	// a single unnamed cluster is watched.
+	clusterName string
+	// namespace is the namespace watched by the controller, empty when all
+	// namespaces are watched.
+	namespace string
+	// notificationPolicies routes notifications by NotificationPolicy
+	// resources, which requires their CRD to be installed.
+	notificationPolicies bool
 }
 
 // NewController returns a new controller
 func NewController(
 	kubeclientset kubernetes.Interface,
-	jobInformer batchesinformers.JobInformer) *Controller {
+	jobInformer batchesinformers.JobInformer,
+	cronJobInformer batchesinformers.CronJobInformer,
+	podInformer coreinformers.PodInformer,
+	namespaceInformer coreinformers.NamespaceInformer,
+	config controllerConfig) *Controller {
 
 	utilruntime.Must(scheme.AddToScheme(scheme.Scheme))
 	eventBroadcaster := record.NewBroadcaster()
@@ -66,276 +269,1124 @@ func NewController(
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
 	controller := &Controller{
-		jobsLister: jobInformer.Lister(),
-		jobsSynced: jobInformer.Informer().HasSynced,
-		recorder:   recorder,
-	}
-	serverStartTime = time.Now().Local()
-	var notifiedJobs sync.Map
-
-	notifications, err := notification.NewNotifications()
-	if err != nil {
-		klog.Fatalf("Error creating notifications: %s", err)
+		kubeclientset: kubeclientset,
+		jobsLister:    jobInformer.Lister(),
+		jobsSynced:    jobInformer.Informer().HasSynced,
+		cronJobLister: cronJobInformer.Lister(),
+		cronJobSynced: cronJobInformer.Informer().HasSynced,
+		podsIndexer:   podInformer.Informer().GetIndexer(),
+		podsSynced:    podInformer.Informer().HasSynced,
+		recorder:      recorder,
+		informers: map[string]cache.SharedIndexInformer{
+			"jobs":     jobInformer.Informer(),
+			"cronjobs": cronJobInformer.Informer(),
+			"pods":     podInformer.Informer(),
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			newRateLimiter(),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: config.queueName("Jobs")},
+		),
+		notifiedJobs:    newNotifiedJobs(),
+		routeGroups:     newRouteGroups(),
+		catchUpSince:    time.Now().Add(-config.catchUpWindow),
+		startedAt:       time.Now(),
+		config:          config,
+		missedSchedules: make(map[types.UID]string),
 	}
-	subscriptions := monitoring.NewSubscription()
-	datadogSubscription := subscriptions["datadog"]
 
-	regexEnv := os.Getenv("CRONJOB_REGEX")
-	var regex *regexp.Regexp
-	if regexEnv != "" {
-		regex = regexp.MustCompile(regexEnv)
+	if namespaceInformer != nil {
+		controller.namespaceLister = namespaceInformer.Lister()
+		controller.namespacesSynced = namespaceInformer.Informer().HasSynced
+		controller.informers["namespaces"] = namespaceInformer.Informer()
 	}
 
 	klog.Info("Setting event handlers")
 	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(new any) {
+		AddFunc: func(obj any) {
+			controller.recordJobMetrics(nil, obj.(*batchv1.Job))
+			controller.enqueueJob(obj)
+		},
+		UpdateFunc: func(old, new any) {
 			newJob := new.(*batchv1.Job)
-			klog.Infof("Job added: %v", newJob.Status)
-
-			cronJob, err := getCronJobNameFromOwnerReferences(kubeclientset, newJob)
-			if err != nil {
-				klog.Errorf("Get cronjob failed: %v", err)
-			}
-
-			if regex != nil && !regex.MatchString(cronJob) {
+			oldJob := old.(*batchv1.Job)
+			if newJob.ResourceVersion == oldJob.ResourceVersion {
 				return
 			}
-
-			if newJob.CreationTimestamp.Sub(serverStartTime).Seconds() < 0 {
-				return
+			controller.recordJobMetrics(oldJob, newJob)
+			controller.enqueueJob(new)
+		},
+		DeleteFunc: func(obj any) {
+			job, ok := obj.(*batchv1.Job)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
+				if job, ok = tombstone.Obj.(*batchv1.Job); !ok {
+					return
+				}
 			}
+			controller.notifiedJobs.Delete(string(job.UID))
+		},
+	})
 
-			if v, ok := notifiedJobs.Load(newJob.Name); ok && v.(bool) {
+	err := podInformer.Informer().AddIndexers(cache.Indexers{jobUIDIndex: indexPodByJobUID})
+	if err != nil {
+		klog.Fatalf("Error adding pod indexer: %s", err)
+	}
+	// Pod events drive the "pod left Pending" transition, so start
+	// notifications are sent as soon as a Pod is scheduled and running.
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueuePodOwner,
+		UpdateFunc: func(old, new any) {
+			newPod := new.(*corev1.Pod)
+			oldPod := old.(*corev1.Pod)
+			if newPod.Status.Phase == oldPod.Status.Phase {
 				return
 			}
+			controller.enqueuePodOwner(new)
+		},
+	})
 
-			klog.Infof("Job started: %v", newJob.Status)
+	return controller
+}
 
-			jobPod, err := getPodFromControllerUID(kubeclientset, newJob)
-			if err != nil {
-				klog.Errorf("Get pods failed: %v", err)
-				return
-			}
+// Run is Kubernetes Controller execute method.
+// It starts workers goroutines that process the workqueue and blocks until
+// stopCh is closed, at which point it shuts down the workqueue and waits for
+// the workers to finish their current item. The health probes report the
+// controller as alive while Run is in progress, and as ready once the caches
+// synced.
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+	c.running.Store(true)
+	defer c.running.Store(false)
 
-			err = waitForPodRunning(kubeclientset, jobPod)
-			if err != nil {
-				klog.Errorf("Error waiting for pod to become running: %v", jobPod)
-				return
-			}
+	klog.Info("Starting kubernetes job notify controller")
 
-			klog.Infof("Job started: %v", newJob.Status)
-			messageParam := notification.MessageTemplateParam{
-				JobName:     newJob.Name,
-				CronJobName: cronJob,
-				Namespace:   newJob.Namespace,
-				StartTime:   newJob.Status.StartTime,
-				Annotations: newJob.Spec.Template.Annotations,
-			}
-			for name, n := range notifications {
-				if err := n.NotifyStart(messageParam); err != nil {
-					klog.Errorf("Failed %s notification: %v", name, err)
-				}
-			}
+	klog.Info("Waiting for informer caches to sync")
+	cacheSyncs := []cache.InformerSynced{c.jobsSynced, c.cronJobSynced, c.podsSynced}
+	if c.namespacesSynced != nil {
+		cacheSyncs = append(cacheSyncs, c.namespacesSynced)
+	}
+	if c.policiesSynced != nil {
+		cacheSyncs = append(cacheSyncs, c.policiesSynced, c.secretsSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, cacheSyncs...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	c.synced.Store(true)
+
+	klog.Infof("Starting %d workers", workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
+	}
 
-			klog.V(4).Infof("Job %s: Start notification sent, waiting for completion", newJob.Name)
-		},
-		UpdateFunc: func(old, new any) {
-			newJob := new.(*batchv1.Job)
-			oldJob := old.(*batchv1.Job)
+	if c.policyQueue != nil {
+		defer c.policyQueue.ShutDown()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runPolicyWorker, time.Second, stopCh)
+		}()
+	}
 
-			klog.Infof("oldJob.Status:%v", oldJob.Status)
-			klog.Infof("newJob.Status:%v", newJob.Status)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(func() { c.flushRouteGroups(time.Now()) }, routeGroupFlushPeriod, stopCh)
+	}()
+
+	if c.config.missedScheduleGracePeriod > 0 {
+		klog.Infof("Checking CronJob schedules every %v", c.config.missedScheduleCheckInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.checkMissedSchedules, c.config.missedScheduleCheckInterval, stopCh)
+		}()
+	}
 
-			cronJobName, err := getCronJobNameFromOwnerReferences(kubeclientset, newJob)
-			if err != nil {
-				klog.Errorf("Get cronjob failed: %v", err)
-			}
+	klog.Info("Started workers")
+	<-stopCh
+	klog.Info("Shutting down workers")
+	c.workqueue.ShutDown()
+	if c.policyQueue != nil {
+		c.policyQueue.ShutDown()
+	}
+	wg.Wait()
 
-			if regex != nil && !regex.MatchString(cronJobName) {
-				return
-			}
+	return nil
+}
 
-			klog.Infof("Job %s: CreationTime=%v, ServerStartTime=%v, TimeDiff=%.2f seconds",
-				newJob.Name, newJob.CreationTimestamp, serverStartTime,
-				newJob.CreationTimestamp.Sub(serverStartTime).Seconds())
+// queueName returns the name of a work queue, which labels its metrics. The
+// cluster and the namespace of the controller are appended when set, so that
+// the queues of the controllers do not share metrics.
+func (config controllerConfig) queueName(name string) string {
+	for _, part := range []string{config.clusterName, config.namespace} {
+		if part != "" {
+			name += "/" + part
+		}
+	}
+	return name
+}
 
-			if newJob.CreationTimestamp.Sub(serverStartTime).Seconds() < 0 {
-				klog.Infof("Job %s: Skipping notification - Job created before server start", newJob.Name)
-				return
-			}
+// newRateLimiter returns the rate limiter of the work queues. Failed items
+// are retried with exponential backoff from retryBaseDelay up to
+// retryMaxDelay, and all items together are limited to 10 per second as with
+// the default controller rate limiter.
+func newRateLimiter() workqueue.TypedRateLimiter[string] {
+	return workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](retryBaseDelay, retryMaxDelay),
+		&workqueue.TypedBucketRateLimiter[string]{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
 
-			oldSucceeded := oldJob.Status.Succeeded == intTrue
-			newSucceeded := newJob.Status.Succeeded == intTrue
-			oldFailed := oldJob.Status.Failed == intTrue
-			newFailed := newJob.Status.Failed == intTrue
+func (c *Controller) enqueueJob(obj any) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
 
-			if oldSucceeded == newSucceeded && oldFailed == newFailed {
-				klog.V(4).Infof("Job %s: Status unchanged, skipping notification", newJob.Name)
-				return
-			}
+// enqueuePodOwner enqueues the Job that owns the Pod.
+func (c *Controller) enqueuePodOwner(obj any) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "Job" {
+		return
+	}
+	c.workqueue.Add(pod.Namespace + "/" + ownerRef.Name)
+}
 
-			if v, ok := notifiedJobs.Load(newJob.Name); ok && v.(bool) {
-				klog.Infof("Job %s: Skipping notification - Already notified", newJob.Name)
-				return
-			}
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
 
-			// Only proceed for newly succeeded or newly failed
-			if !((newSucceeded && !oldSucceeded) || (newFailed && !oldFailed)) {
-				return
-			}
+func (c *Controller) processNextWorkItem() bool {
+	key, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(key)
 
-			jobPod, err := getPodFromControllerUID(kubeclientset, newJob)
-			if err != nil {
-				klog.Errorf("Get pods failed: %v", err)
-				return
-			}
+	err := c.syncHandler(key)
+	if err == nil {
+		c.workqueue.Forget(key)
+		return true
+	}
 
-			if err = waitForPodRunning(kubeclientset, jobPod); err != nil {
-				klog.Errorf("Error waiting for pod to become running: %v", err)
-				return
-			}
+	if c.workqueue.NumRequeues(key) < maxRetries {
+		klog.Errorf("Error syncing job %s, requeuing: %v", key, err)
+		c.workqueue.AddRateLimited(key)
+		return true
+	}
 
-			annotations := newJob.Spec.Template.Annotations
-			lm := getLogMode(annotations, logModeAnnotationName)
-			jobLogStr := getJobLogs(kubeclientset, jobPod, cronJobName, lm)
-
-			messageParam := notification.MessageTemplateParam{
-				JobName:        newJob.Name,
-				CronJobName:    cronJobName,
-				Namespace:      newJob.Namespace,
-				StartTime:      newJob.Status.StartTime,
-				CompletionTime: newJob.Status.CompletionTime,
-				Log:            jobLogStr,
-				Annotations:    annotations,
-			}
+	c.workqueue.Forget(key)
+	utilruntime.HandleError(fmt.Errorf("dropping job %s out of the queue after %d retries: %v", key, maxRetries, err))
+	return true
+}
 
-			jobInfo := monitoring.JobInfo{
-				CronJobName: cronJobName,
-				Name:        newJob.Name,
-				Namespace:   newJob.Namespace,
-				Annotations: newJob.Spec.Template.Annotations,
-			}
+// syncHandler compares the current state of the Job with the notifications
+// already delivered for it and sends the ones that are still missing.
+func (c *Controller) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
 
-			if newSucceeded && !oldSucceeded {
-				klog.Infof("Job succeeded: Name: %s: Status: %v", newJob.Name, newJob.Status)
-				for name, n := range notifications {
-					if err := n.NotifySuccess(messageParam); err != nil {
-						klog.Errorf("Failed %s notification for job %s: %v", name, newJob.Name, err)
-					}
-				}
-				if os.Getenv("DATADOG_ENABLE") == "true" {
-					if err := datadogSubscription.SuccessEvent(jobInfo); err != nil {
-						klog.Errorf("Fail event subscribe.: %v", err)
-					}
-				}
-			} else {
-				klog.Infof("Job failed: Name: %s: Status: %v", newJob.Name, newJob.Status)
-				for name, n := range notifications {
-					if err := n.NotifyFailed(messageParam); err != nil {
-						klog.Errorf("Failed %s failure notification for job %s: %v", name, newJob.Name, err)
-					}
-				}
-				if os.Getenv("DATADOG_ENABLE") == "true" {
-					if err := datadogSubscription.FailEvent(jobInfo); err != nil {
-						klog.Errorf("Fail event subscribe.: %v", err)
-					}
-				}
-			}
+	job, err := c.jobsLister.Jobs(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-			isCompleted := isCompletedJob(kubeclientset, newJob)
-			klog.Infof("Job %s: Setting notified flag to %t", newJob.Name, isCompleted)
-			notifiedJobs.Store(newJob.Name, isCompleted)
-		},
-		DeleteFunc: func(obj any) {
-			deletedJob := obj.(*batchv1.Job)
-			notifiedJobs.Delete(deletedJob.Name)
+	klog.V(4).Infof("Job %s: Status: %v", key, job.Status)
+
+	if !c.isNamespaceWatched(job.Namespace) {
+		return nil
+	}
+
+	cronJobName := getCronJobNameFromOwnerReferences(job)
+	regex, filter := c.getFilters()
+	if regex != nil && !regex.MatchString(cronJobName) {
+		return nil
+	}
+
+	var cronJob *batchv1.CronJob
+	if cronJobName != "" {
+		cronJob, err = c.cronJobLister.CronJobs(job.Namespace).Get(cronJobName)
+		if err != nil {
+			klog.Errorf("Get cronjob failed: %v", err)
+		}
+	}
+	annotations := c.getAnnotations(job, cronJob)
+
+	uid := string(job.UID)
+	c.notifiedJobs.Load(uid, getNotifiedEvents(job.Annotations), getDeliveryStatus(job.Annotations))
+
+	finished := getFinishedCondition(job)
+	succeeded := finished != nil && isSucceededCondition(finished.Type)
+	failed := finished != nil && !succeeded
+
+	c.skipStaleEvent(key, uid, eventStart, jobStartTime(job))
+	if succeeded {
+		c.skipStaleEvent(key, uid, eventSuccess, jobFinishedTime(finished))
+	}
+	if failed {
+		c.skipStaleEvent(key, uid, eventFailed, jobFinishedTime(finished))
+	}
+
+	expectedDuration := c.getExpectedDuration(job, annotations)
+	running := !succeeded && !failed
+
+	var retry string
+	if running {
+		retry = getRetryEvent(job, annotations)
+	}
+
+	if c.notifiedJobs.IsDone(uid, eventStart) &&
+		(!running || expectedDuration == 0 || c.notifiedJobs.IsDone(uid, eventLongRunning)) &&
+		(retry == "" || c.notifiedJobs.IsDone(uid, retry)) &&
+		(!succeeded || c.notifiedJobs.IsDone(uid, eventSuccess)) &&
+		(!failed || c.notifiedJobs.IsDone(uid, eventFailed)) {
+		klog.V(4).Infof("Job %s: Status unchanged, skipping notification", key)
+		// Retry writing the events if it failed after their delivery.
+		if !c.notifiedJobs.IsPersisted(uid) {
+			return c.persistNotifiedEvents(job)
+		}
+		return nil
+	}
+
+	if !c.isOptedIn(annotations) {
+		klog.V(4).Infof("Job %s: Not opted in, skipping notification", key)
+		return nil
+	}
+
+	if filter != nil {
+		matched, err := filter.Matches(job, cronJob)
+		if err != nil {
+			klog.Warningf("Job %s: Not notified, JOB_CEL_FILTER failed: %v", key, err)
+		}
+		if !matched {
+			return nil
+		}
+	}
+
+	jobPod, err := c.getLatestJobPod(job)
+	if err != nil {
+		return fmt.Errorf("get pods failed: %w", err)
+	}
+
+	if retry != "" && !c.notifiedJobs.IsDone(uid, retry) {
+		if err = c.notifyRetry(job, cronJobName, cronJob, retry); err != nil {
+			return err
+		}
+	}
+
+	// Wait for the pod to leave Pending; its update event requeues the Job.
+	if running && (jobPod == nil || jobPod.Status.Phase == corev1.PodPending) {
+		klog.V(4).Infof("Job %s: Waiting for pod to become running", key)
+		if jobPod == nil || c.config.stuckPodThreshold == 0 || c.notifiedJobs.IsDone(uid, eventStuck) {
+			return nil
+		}
+		return c.checkStuck(key, job, cronJobName, cronJob, jobPod)
+	}
+
+	if !c.notifiedJobs.IsDone(uid, eventStart) {
+		klog.Infof("Job started: %v", job.Status)
+		messageParam := c.newMessageTemplateParam(job, cronJobName, cronJob)
+		err = c.notify(job, uid, eventStart, messageParam, notification.Notification.NotifyStart,
+			monitoring.JobInfo{}, nil)
+		if err != nil {
+			return err
+		}
+		if err = c.persistNotifiedEvents(job); err != nil {
+			return err
+		}
+		klog.V(4).Infof("Job %s: Start notification sent, waiting for completion", key)
+	}
+
+	if running {
+		if expectedDuration == 0 || c.notifiedJobs.IsDone(uid, eventLongRunning) {
+			return nil
+		}
+		return c.checkLongRunning(key, job, cronJobName, cronJob, jobPod, expectedDuration)
+	}
+
+	switch {
+	case succeeded && !c.notifiedJobs.IsDone(uid, eventSuccess):
+	case failed && !c.notifiedJobs.IsDone(uid, eventFailed):
+	default:
+		return nil
+	}
+
+	lm := getLogMode(annotations, logModeAnnotationName)
+	var jobLogStr string
+	if jobPod != nil {
+		jobLogStr = getJobLogs(c.kubeclientset, *jobPod, cronJobName, lm)
+	}
+
+	messageParam := c.newMessageTemplateParam(job, cronJobName, cronJob)
+	messageParam.CompletionTime = job.Status.CompletionTime
+	messageParam.Log = jobLogStr
+
+	jobInfo := monitoring.JobInfo{
+		ClusterName: c.config.clusterName,
+		CronJobName: cronJobName,
+		Name:        job.Name,
+		Namespace:   job.Namespace,
+		Annotations: annotations,
+	}
+
+	if succeeded {
+		klog.Infof("Job succeeded: Name: %s: Status: %v", job.Name, job.Status)
+		err = c.notify(job, uid, eventSuccess, messageParam, notification.Notification.NotifySuccess,
+			jobInfo, monitoring.Subscription.SuccessEvent)
+	} else {
+		klog.Infof("Job failed: Name: %s: Reason: %s: Status: %v", job.Name, finished.Reason, job.Status)
+		messageParam.FailureReason = finished.Reason
+		messageParam.FailureMessage = finished.Message
+		var failedPod *corev1.Pod
+		failedPod, err = c.getLatestJobPod(job, corev1.PodFailed)
+		if err != nil {
+			return fmt.Errorf("get pods failed: %w", err)
+		}
+		if failedPod != nil {
+			messageParam.ContainerFailures = getContainerFailures(failedPod)
+		}
+		messageParam.Events = c.getJobEvents(job)
+		err = c.notify(job, uid, eventFailed, messageParam, notification.Notification.NotifyFailed,
+			jobInfo, monitoring.Subscription.FailEvent)
+	}
+	if err != nil {
+		return err
+	}
+	if cronJob != nil {
+		c.recordCronJobResult(cronJob, job, succeeded)
+	}
+	return c.persistNotifiedEvents(job)
+}
+
+// getRetryEvent returns the event of the latest failed attempt of a Job that
+// opted in to retry notifications, or an empty string.
+func getRetryEvent(job *batchv1.Job, annotations map[string]string) string {
+	if annotations[notifyRetriesAnnotationName] != "true" || job.Status.Failed == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", eventRetry, job.Status.Failed)
+}
+
+// notifyRetry notifies that an attempt of the Job failed and is retried, with
+// the exit code and the last log lines of the failed Pod.
+func (c *Controller) notifyRetry(job *batchv1.Job, cronJobName string, cronJob *batchv1.CronJob, event string) error {
+	backoffLimit := int32(defaultBackoffLimit)
+	if job.Spec.BackoffLimit != nil {
+		backoffLimit = *job.Spec.BackoffLimit
+	}
+
+	messageParam := c.newMessageTemplateParam(job, cronJobName, cronJob)
+	messageParam.Attempt = int(job.Status.Failed) + 1
+	messageParam.MaxAttempts = int(backoffLimit) + 1
+
+	failedPod, err := c.getLatestJobPod(job, corev1.PodFailed)
+	if err != nil {
+		return fmt.Errorf("get pods failed: %w", err)
+	}
+	if failedPod != nil {
+		messageParam.ContainerFailures = getContainerFailures(failedPod)
+		lm := getLogMode(messageParam.Annotations, logModeAnnotationName)
+		messageParam.LogExcerpt = tailLines(getJobLogs(c.kubeclientset, *failedPod, cronJobName, lm), retryLogLines)
+	}
+
+	klog.Infof("Job retrying: Name: %s: Attempt: %d/%d", job.Name, messageParam.Attempt, messageParam.MaxAttempts)
+	err = c.notify(job, string(job.UID), event, messageParam, notification.Notification.NotifyRetry,
+		monitoring.JobInfo{}, nil)
+	if err != nil {
+		return err
+	}
+	return c.persistNotifiedEvents(job)
+}
+
+// getContainerFailures returns the init and regular containers of the Pod
+// that terminated unsuccessfully. It is empty for Pods that failed without a
+// container failing, e.g. evicted Pods.
+func getContainerFailures(pod *corev1.Pod) []notification.ContainerFailure {
+	var failures []notification.ContainerFailure
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		t := cs.State.Terminated
+		if t == nil || t.ExitCode == 0 {
+			continue
+		}
+		failures = append(failures, notification.ContainerFailure{
+			Name:     cs.Name,
+			Reason:   t.Reason,
+			ExitCode: t.ExitCode,
+			Signal:   t.Signal,
+			Message:  strings.TrimSpace(t.Message),
+		})
+	}
+	return failures
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkStuck notifies a Job whose Pod has been Pending for longer than the
+// stuck threshold for a reason that will not resolve by itself, or requeues it
+// to check again later. Changes of the Pod conditions and container states
+// do not change its phase, so they are not picked up by the Pod informer.
+func (c *Controller) checkStuck(key string, job *batchv1.Job, cronJobName string, cronJob *batchv1.CronJob,
+	jobPod *corev1.Pod) error {
+
+	pendingFor := time.Since(jobPod.CreationTimestamp.Time)
+	if pendingFor < c.config.stuckPodThreshold {
+		c.workqueue.AddAfter(key, c.config.stuckPodThreshold-pendingFor)
+		return nil
+	}
+
+	reason, message := getPodStuckReason(jobPod)
+	if reason == "" {
+		c.workqueue.AddAfter(key, stuckPodRecheckInterval)
+		return nil
+	}
+
+	klog.Infof("Job stuck: Name: %s: Pod: %s: Reason: %s: %s", job.Name, jobPod.Name, reason, message)
+	messageParam := c.newMessageTemplateParam(job, cronJobName, cronJob)
+	messageParam.PodStatus = getPodStatus(jobPod)
+	messageParam.StuckReason = reason
+	messageParam.StuckMessage = message
+	err := c.notify(job, string(job.UID), eventStuck, messageParam, notification.Notification.NotifyStuck,
+		monitoring.JobInfo{}, nil)
+	if err != nil {
+		return err
+	}
+	return c.persistNotifiedEvents(job)
+}
+
+// getPodStuckReason returns why a Pending Pod cannot start, from its
+// PodScheduled condition or the waiting state of its containers, or an empty
+// reason if it is expected to start eventually.
+func getPodStuckReason(pod *corev1.Pod) (reason string, message string) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return c.Reason, c.Message
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if cs.State.Waiting == nil {
+			continue
+		}
+		if funk.ContainsString(stuckContainerReasons, cs.State.Waiting.Reason) {
+			return cs.State.Waiting.Reason, fmt.Sprintf("container %s: %s", cs.Name, cs.State.Waiting.Message)
+		}
+	}
+	return "", ""
+}
+
+// checkLongRunning notifies a Job that has been running for longer than
+// expectedDuration, or requeues it for when that happens.
+func (c *Controller) checkLongRunning(key string, job *batchv1.Job, cronJobName string, cronJob *batchv1.CronJob,
+	jobPod *corev1.Pod, expectedDuration time.Duration) error {
+
+	runningFor := time.Since(jobStartTime(job))
+	if runningFor < expectedDuration {
+		c.workqueue.AddAfter(key, expectedDuration-runningFor)
+		return nil
+	}
+
+	klog.Infof("Job running longer than expected: Name: %s: Expected: %v", job.Name, expectedDuration)
+	messageParam := c.newMessageTemplateParam(job, cronJobName, cronJob)
+	messageParam.ExpectedDuration = expectedDuration
+	messageParam.PodStatus = getPodStatus(jobPod)
+	err := c.notify(job, string(job.UID), eventLongRunning, messageParam, notification.Notification.NotifyLongRunning,
+		monitoring.JobInfo{}, nil)
+	if err != nil {
+		return err
+	}
+	return c.persistNotifiedEvents(job)
+}
+
+// isOptedIn reports whether the resolved annotations opt in to notifications
+// in opt-in mode. Without opt-in mode everything is notified.
+func (c *Controller) isOptedIn(annotations map[string]string) bool {
+	return !c.config.optIn || annotations[enabledAnnotationName] == "true"
+}
+
+// getExpectedDuration returns the duration after which the Job is notified
+// as running longer than expected, or zero if it is not notified.
+func (c *Controller) getExpectedDuration(job *batchv1.Job, annotations map[string]string) time.Duration {
+	a, ok := annotations[expectedDurationAnnotationName]
+	if !ok {
+		return c.config.defaultExpectedDuration
+	}
+	d, err := time.ParseDuration(a)
+	if err != nil {
+		klog.Errorf("Job %s/%s: Invalid %s annotation %q: %v", job.Namespace, job.Name, expectedDurationAnnotationName, a, err)
+		return c.config.defaultExpectedDuration
+	}
+	return d
+}
+
+// getPodStatus summarizes the phase of the Pod and the reasons its
+// containers are waiting, e.g. "Running (app: CrashLoopBackOff)".
+func getPodStatus(pod *corev1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	var reasons []string
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			reasons = append(reasons, cs.Name+": "+cs.State.Waiting.Reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return string(pod.Status.Phase)
+	}
+	return fmt.Sprintf("%s (%s)", pod.Status.Phase, strings.Join(reasons, ", "))
+}
+
+// skipStaleEvent marks an event that happened before the catch-up window as
+// done without notifying it.
+func (c *Controller) skipStaleEvent(key, uid, event string, eventTime time.Time) {
+	if c.notifiedJobs.IsDone(uid, event) || !eventTime.Before(c.catchUpSince) {
+		return
+	}
+	klog.V(4).Infof("Job %s: Skipping %s notification - Event at %v is older than catch-up window", key, event, eventTime)
+	c.notifiedJobs.MarkDone(uid, event)
+}
+
+// persistNotifiedEvents writes the events delivered so far and the delivery
+// status to the Job annotations, so that they are not sent again after a
+// restart. If it fails, the Job is requeued and the write retried, see
+// notifiedJobs.IsPersisted.
+func (c *Controller) persistNotifiedEvents(job *batchv1.Job) error {
+	annotations := map[string]string{
+		notifiedEventsAnnotationName: strings.Join(c.notifiedJobs.DoneEvents(string(job.UID)), ","),
+	}
+	status := c.notifiedJobs.Status(string(job.UID))
+	if !status.notifiedAt.IsZero() {
+		annotations[notifiedAtAnnotationName] = status.notifiedAt.UTC().Format(time.RFC3339)
+		annotations[notifiedSinksAnnotationName] = strings.Join(status.sinks, ",")
+	}
+	if status.slackPermalink != "" {
+		annotations[slackPermalinkAnnotationName] = status.slackPermalink
+	}
+	changed := false
+	for k, v := range annotations {
+		if job.Annotations[k] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		c.notifiedJobs.MarkPersisted(string(job.UID))
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
 		},
 	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeclientset.BatchV1().Jobs(job.Namespace).Patch(context.TODO(), job.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to record notified events on job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	c.notifiedJobs.MarkPersisted(string(job.UID))
+	return nil
+}
 
-	return controller
+// recordCronJobResult annotates the CronJob with the result of the finished
+// Job and the number of consecutive Jobs with that result. The CronJob is
+// read from the API server rather than the cache, so that Jobs finishing in
+// quick succession are all counted. Failures are logged but not retried, as
+// the annotations are informational only.
+func (c *Controller) recordCronJobResult(cronJob *batchv1.CronJob, job *batchv1.Job, succeeded bool) {
+	cronJobs := c.kubeclientset.BatchV1().CronJobs(cronJob.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := cronJobs.Get(context.TODO(), cronJob.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations[lastJobAnnotationName] == job.Name {
+			return nil
+		}
+		result, streakAnnotationName := eventFailed, consecutiveFailuresAnnotationName
+		if succeeded {
+			result, streakAnnotationName = eventSuccess, consecutiveSuccessesAnnotationName
+		}
+		streak := 1
+		if latest.Annotations[lastResultAnnotationName] == result {
+			if n, err := strconv.Atoi(latest.Annotations[streakAnnotationName]); err == nil {
+				streak = n + 1
+			}
+		}
+		annotations := map[string]string{
+			lastJobAnnotationName:              job.Name,
+			lastResultAnnotationName:           result,
+			consecutiveFailuresAnnotationName:  "0",
+			consecutiveSuccessesAnnotationName: "0",
+		}
+		annotations[streakAnnotationName] = strconv.Itoa(streak)
+
+		// The resourceVersion makes the patch fail with a conflict if the
+		// CronJob changed since it was read.
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"resourceVersion": latest.ResourceVersion,
+				"annotations":     annotations,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = cronJobs.Patch(context.TODO(), cronJob.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Failed to record result of job %s/%s on cronjob %s: %v", job.Namespace, job.Name, cronJob.Name, err)
+	}
 }
 
-// Run is Kubernetes Controller execute method
-func (c *Controller) Run(stopCh <-chan struct{}) error {
-	defer utilruntime.HandleCrash()
+func getNotifiedEvents(annotations map[string]string) []string {
+	a, ok := annotations[notifiedEventsAnnotationName]
+	if !ok || a == "" {
+		return nil
+	}
+	return strings.Split(a, ",")
+}
 
-	klog.Info("Starting kubernetes job notify controller")
+// getDeliveryStatus reads the delivery status persisted on the Job.
+func getDeliveryStatus(annotations map[string]string) deliveryStatus {
+	var status deliveryStatus
+	if t, err := time.Parse(time.RFC3339, annotations[notifiedAtAnnotationName]); err == nil {
+		status.notifiedAt = t
+	}
+	if s := annotations[notifiedSinksAnnotationName]; s != "" {
+		status.sinks = strings.Split(s, ",")
+	}
+	status.slackPermalink = annotations[slackPermalinkAnnotationName]
+	return status
+}
 
-	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.jobsSynced); !ok {
-		return fmt.Errorf("failed to wait for caches to sync")
+func jobStartTime(job *batchv1.Job) time.Time {
+	if job.Status.StartTime != nil {
+		return job.Status.StartTime.Time
 	}
+	return job.CreationTimestamp.Time
+}
 
-	klog.Info("Started workers")
-	<-stopCh
-	klog.Info("Shutting down workers")
+// getFinishedCondition returns the condition that marks the Job as finished,
+// or nil while it is still running. SuccessCriteriaMet and FailureTarget are
+// set before Complete and Failed, while the remaining pods are terminated,
+// so they are honored too in order to notify as early as possible.
+func getFinishedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	var finished *batchv1.JobCondition
+	for i, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete, batchv1.JobFailed:
+			return &job.Status.Conditions[i]
+		case batchv1.JobSuccessCriteriaMet, batchv1.JobFailureTarget:
+			finished = &job.Status.Conditions[i]
+		}
+	}
+	return finished
+}
+
+func isSucceededCondition(conditionType batchv1.JobConditionType) bool {
+	return conditionType == batchv1.JobComplete || conditionType == batchv1.JobSuccessCriteriaMet
+}
+
+func jobFinishedTime(condition *batchv1.JobCondition) time.Time {
+	if condition.LastTransitionTime.IsZero() {
+		return time.Now()
+	}
+	return condition.LastTransitionTime.Time
+}
+
+// target is a sink an event is delivered to. Its name is the key its delivery
+// is recorded under, e.g. "slack", or "slack@team-alerts/0" for the first
+// destination of the NotificationPolicy team-alerts.
+type target struct {
+	name         string
+	sink         string
+	notification notification.Notification
+	subscription monitoring.Subscription
+	messageParam notification.MessageTemplateParam
+	jobInfo      monitoring.JobInfo
+	// group is the key of the group of the event on its route, empty unless
+	// the route groups events, see routeGroups.
+	group         string
+	groupInterval time.Duration
+	// err is why the target could not be resolved, e.g. a missing Secret.
+	err error
+}
+
+// notify delivers an event to every target that has not yet received it, so
+// a requeue after a partial failure does not resend the event to the targets
+// that already got it. The targets are the configured sinks unless a
+// NotificationPolicy selects obj, see getTargets. The outcome of each
+// delivery is recorded as a Kubernetes Event on obj. subscribe is nil for
+// events that are not sent to Datadog.
+func (c *Controller) notify(obj runtime.Object, uid string, event string,
+	messageParam notification.MessageTemplateParam,
+	notify func(notification.Notification, notification.MessageTemplateParam) (*notification.Delivery, error),
+	jobInfo monitoring.JobInfo,
+	subscribe func(monitoring.Subscription, monitoring.JobInfo) error) error {
+
+	var errs []error
+	held := false
+	for _, t := range c.getTargets(obj, event, messageParam, jobInfo) {
+		if t.sink == datadogSinkName && subscribe == nil {
+			continue
+		}
+		if c.notifiedJobs.IsSent(uid, event, t.name) {
+			continue
+		}
+		if t.group != "" && t.err == nil &&
+			c.routeGroups.hold(groupedNotification{obj: obj, uid: uid, event: event, target: t, notify: notify}, time.Now()) {
+			klog.Infof("Held back %s %s notification for job %s in group %s", t.name, event, uid, t.group)
+			held = true
+			continue
+		}
+		var delivery *notification.Delivery
+		var duration time.Duration
+		err := t.err
+		if err == nil {
+			start := time.Now()
+			if t.sink == datadogSinkName {
+				err = subscribe(t.subscription, t.jobInfo)
+				delivery = &notification.Delivery{}
+			} else {
+				delivery, err = notify(t.notification, t.messageParam)
+			}
+			duration = time.Since(start)
+		}
+		recordDeliveryMetrics(t.sink, err, duration)
+		if t.err == nil {
+			healthChecks.recordDelivery(t.sink, err, time.Now())
+		}
+		if err != nil {
+			// Webhook URLs are credentials, and Events are readable by
+			// everyone with access to the namespace.
+			err = redactURL(err)
+			klog.Errorf("Failed %s %s notification for job %s: %v", t.name, event, uid, err)
+			c.recorder.Eventf(obj, corev1.EventTypeWarning, reasonNotificationFailed,
+				"Failed to send %s notification to %s: %v", event, t.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+			continue
+		}
+		c.notifiedJobs.MarkSent(uid, event, t.name)
+		if delivery != nil {
+			c.notifiedJobs.MarkDelivered(uid, t.name, delivery.Permalink, time.Now())
+			c.recordDelivery(obj, event, t.name, delivery)
+			if t.group != "" {
+				c.routeGroups.markSent(t.group, time.Now(), t.groupInterval)
+			}
+		}
+	}
 
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	// The event is done once the notifications held back are sent, see
+	// flushRouteGroups.
+	if !held {
+		c.notifiedJobs.MarkDone(uid, event)
+	}
 	return nil
 }
 
-func isCompletedJob(kubeclientset kubernetes.Interface, job *batchv1.Job) bool {
+// flushRouteGroups sends the notifications held back by each route group
+// whose interval passed in one notification per group. It is the last of
+// them, listing the others in MessageTemplateParam.Grouped. The Jobs of the
+// sent notifications are requeued, so that their events are recorded as
+// done. If sending fails, it is retried after another interval, up to
+// maxRetries times.
+func (c *Controller) flushRouteGroups(now time.Time) {
+	for _, batch := range c.routeGroups.due(now) {
+		last := batch[len(batch)-1]
+		t := last.target
+		messageParam := t.messageParam
+		messageParam.Grouped = nil
+		for _, n := range batch[:len(batch)-1] {
+			p := n.target.messageParam
+			messageParam.Grouped = append(messageParam.Grouped, notification.GroupedEvent{
+				Event:       n.event,
+				Namespace:   p.Namespace,
+				CronJobName: p.CronJobName,
+				JobName:     p.JobName,
+			})
+		}
 
-	if job.Status.Succeeded == intTrue {
-		return true
+		start := time.Now()
+		delivery, err := last.notify(t.notification, messageParam)
+		recordDeliveryMetrics(t.sink, err, time.Since(start))
+		healthChecks.recordDelivery(t.sink, err, time.Now())
+		if err != nil {
+			err = redactURL(err)
+			for _, n := range batch {
+				c.recorder.Eventf(n.obj, corev1.EventTypeWarning, reasonNotificationFailed,
+					"Failed to send %s notification to %s: %v", n.event, t.name, err)
+			}
+			if c.routeGroups.failed(batch) {
+				klog.Errorf("Dropped %s notification of %d events in group %s after %d retries: %v",
+					t.name, len(batch), t.group, maxRetries, err)
+				continue
+			}
+			klog.Errorf("Failed %s notification of %d events in group %s: %v", t.name, len(batch), t.group, err)
+			continue
+		}
+		c.routeGroups.sent(batch)
+		klog.Infof("Sent %s notification of %d events in group %s", t.name, len(batch), t.group)
+		for _, n := range batch {
+			c.notifiedJobs.MarkSent(n.uid, n.event, t.name)
+			if delivery != nil {
+				c.notifiedJobs.MarkDelivered(n.uid, t.name, delivery.Permalink, time.Now())
+				c.recordDelivery(n.obj, n.event, t.name, delivery)
+			}
+			// Missed schedules of CronJobs are done on the next check.
+			if job, ok := n.obj.(*batchv1.Job); ok {
+				c.enqueueJob(job)
+			}
+		}
 	}
+}
 
-	labelSelector := metav1.LabelSelector{MatchLabels: map[string]string{searchLabel: string(job.UID)}}
+// getDefaultTargets returns a target for each configured sink, or for each
+// receiver the routing tree routes the event to when it is configured. The
+// Datadog subscription is not routed.
+func (c *Controller) getDefaultTargets(obj runtime.Object, event string, messageParam notification.MessageTemplateParam,
+	jobInfo monitoring.JobInfo) []target {
+
+	notifications, datadogSubscription := c.getSinks()
+	var targets []target
+	if root := c.getRoute(); root == nil {
+		for name, n := range notifications {
+			targets = append(targets, target{name: name, sink: name, notification: n, messageParam: messageParam})
+		}
+	} else {
+		e := newRouteEvent(obj, event, messageParam)
+		routed := make(map[string]bool)
+		for _, r := range root.match(e) {
+			if routed[r.receiver] {
+				continue
+			}
+			routed[r.receiver] = true
+			t := target{name: r.receiver, sink: r.receiver, notification: notifications[r.receiver],
+				messageParam: messageParam, group: r.groupKey(e), groupInterval: r.groupInterval}
+			if r.receiver != "msteamsv2" {
+				// Receivers other than the top-level sink have their own
+				// webhook URL.
+				t.messageParam = messageParam.WithoutMsTeamsV2WebhookURL()
+			}
+			if t.notification == nil {
+				t.err = fmt.Errorf("receiver %s is not configured", r.receiver)
+			}
+			targets = append(targets, t)
+		}
+	}
+	if datadogSubscription != nil {
+		targets = append(targets, target{name: datadogSinkName, sink: datadogSinkName,
+			subscription: datadogSubscription, jobInfo: jobInfo})
+	}
+	return targets
+}
 
-	jobPodList, err := kubeclientset.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labels.Set(labelSelector.MatchLabels).String(),
-		Limit:         int64(*job.Spec.BackoffLimit + 1),
-	})
+// compiledConfig is a config with its sinks, filters and routes built, so
+// that they are built once and shared by every controller the config is
+// applied to.
+type compiledConfig struct {
+	config              *fileConfig
+	notifications       map[string]notification.Notification
+	datadogSubscription monitoring.Subscription
+	regex               *regexp.Regexp
+	filter              *jobFilter
+	route               *route
+}
 
+// compileConfig builds the sinks, filters and routes of the config. Sinks are
+// reused from previous, which may be nil, when their config did not change.
+func compileConfig(cfg *fileConfig, previous *compiledConfig) (*compiledConfig, error) {
+	compiled := &compiledConfig{config: cfg}
+	var err error
+	if previous != nil && reflect.DeepEqual(previous.config.Config, cfg.Config) {
+		compiled.notifications = previous.notifications
+	} else {
+		compiled.notifications, err = notification.NewNotifications(cfg.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error creating notifications: %w", err)
+		}
+	}
+	if previous != nil && reflect.DeepEqual(previous.config.Datadog, cfg.Datadog) {
+		compiled.datadogSubscription = previous.datadogSubscription
+	} else {
+		compiled.datadogSubscription = monitoring.NewSubscription(cfg.Datadog)[datadogSinkName]
+	}
+	if cfg.Filters.CronJobRegex != "" {
+		compiled.regex, err = regexp.Compile(cfg.Filters.CronJobRegex)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cronjob regex: %w", err)
+		}
+	}
+	if cfg.Filters.CEL != "" {
+		compiled.filter, err = newJobFilter(cfg.Filters.CEL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CEL filter: %w", err)
+		}
+	}
+	compiled.route, err = cfg.newRoute()
 	if err != nil {
-		return true
+		return nil, fmt.Errorf("error parsing route: %w", err)
 	}
+	return compiled, nil
+}
 
-	if jobPodList.Items != nil && len(jobPodList.Items) != int(*job.Spec.BackoffLimit)+1 {
-		return false
+// applyConfig replaces the sinks, filters and routes with those of the
+// config.
+func (c *Controller) applyConfig(cfg *compiledConfig) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.appliedConfig = cfg.config
+	c.notifications = cfg.notifications
+	c.datadogSubscription = cfg.datadogSubscription
+	c.regex = cfg.regex
+	c.filter = cfg.filter
+	c.route = cfg.route
+}
+
+// getSinks returns the notifications and the Datadog subscription, which is
+// nil unless enabled.
+func (c *Controller) getSinks() (map[string]notification.Notification, monitoring.Subscription) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.notifications, c.datadogSubscription
+}
+
+// getRoute returns the routing tree, which is nil unless configured.
+func (c *Controller) getRoute() *route {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.route
+}
+
+// getFilters returns the CronJob name regex and the CEL filter, which are nil
+// unless configured.
+func (c *Controller) getFilters() (*regexp.Regexp, *jobFilter) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.regex, c.filter
+}
+
+// recordDelivery records a NotificationSent Event on obj, including the Slack
+// channel and message timestamp when the sink returned them.
+func (c *Controller) recordDelivery(obj runtime.Object, event, sink string, delivery *notification.Delivery) {
+	if delivery.Timestamp == "" {
+		c.recorder.Eventf(obj, corev1.EventTypeNormal, reasonNotificationSent,
+			"Sent %s notification to %s", event, sink)
+		return
 	}
-	return true
+	c.recorder.Eventf(obj, corev1.EventTypeNormal, reasonNotificationSent,
+		"Sent %s notification to %s channel %s (ts %s)", event, sink, delivery.Channel, delivery.Timestamp)
 }
 
-func getPodFromControllerUID(kubeclientset kubernetes.Interface, job *batchv1.Job) (corev1.Pod, error) {
-	labelSelector := metav1.LabelSelector{MatchLabels: map[string]string{searchLabel: string(job.UID)}}
-	jobPodList, err := kubeclientset.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labels.Set(labelSelector.MatchLabels).String(),
-		Limit:         int64(*job.Spec.BackoffLimit),
-	})
+// getLatestJobPod returns the most recently created Pod of the Job in one of
+// the given phases, or in any phase if none are given. It returns nil if the
+// Job has no such Pods.
+func (c *Controller) getLatestJobPod(job *batchv1.Job, phases ...corev1.PodPhase) (*corev1.Pod, error) {
+	objs, err := c.podsIndexer.ByIndex(jobUIDIndex, string(job.UID))
 	if err != nil {
-		return corev1.Pod{}, err
+		return nil, err
 	}
-	if jobPodList.Size() == 0 {
-		return corev1.Pod{}, fmt.Errorf("failed get pod list JobPodListSize: %v", jobPodList.Size())
+	var latest *corev1.Pod
+	for _, obj := range objs {
+		pod := obj.(*corev1.Pod)
+		if len(phases) > 0 && !funk.Contains(phases, pod.Status.Phase) {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
 	}
-	if len(jobPodList.Items) == 0 {
-		return corev1.Pod{}, fmt.Errorf("failed get pod list jobPodList.Items): %v", jobPodList.Items)
+	return latest, nil
+}
+
+func indexPodByJobUID(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	if uid, ok := pod.Labels[jobUIDLabel]; ok {
+		return []string{uid}, nil
 	}
-	jobPod := jobPodList.Items[len(jobPodList.Items)-1]
-	return jobPod, nil
+	if uid, ok := pod.Labels[searchLabel]; ok {
+		return []string{uid}, nil
+	}
+	return nil, nil
 }
 
-func getCronJobNameFromOwnerReferences(kubeclientset kubernetes.Interface, job *batchv1.Job) (cronJobName string, err error) {
+// getCronJobNameFromOwnerReferences returns the name of the CronJob that owns
+// the Job, or an empty string if the Job was not created by a CronJob.
+func getCronJobNameFromOwnerReferences(job *batchv1.Job) string {
 	ownerReferences, ok := funk.Filter(job.OwnerReferences,
 		func(ownerReference metav1.OwnerReference) bool {
 			return ownerReference.Kind == "CronJob"
 		}).([]metav1.OwnerReference)
 	if !ok || len(ownerReferences) == 0 {
-		return cronJobName, err
+		return ""
 	}
+	return ownerReferences[0].Name
+}
 
-	cronJobV1, err := kubeclientset.BatchV1().CronJobs(job.Namespace).Get(context.TODO(),
-		ownerReferences[0].Name,
-		metav1.GetOptions{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       ownerReferences[0].Kind,
-				APIVersion: ownerReferences[0].APIVersion,
-			},
-		})
-	if err == nil {
-		return cronJobV1.Name, nil
+// newMessageTemplateParam returns the message fields shared by every event.
+// cronJob may be nil when the Job has no CronJob owner or it was deleted.
+func (c *Controller) newMessageTemplateParam(job *batchv1.Job, cronJobName string, cronJob *batchv1.CronJob) notification.MessageTemplateParam {
+	messageParam := notification.MessageTemplateParam{
+		ClusterName: c.config.clusterName,
+		JobName:     job.Name,
+		CronJobName: cronJobName,
+		Namespace:   job.Namespace,
+		StartTime:   job.Status.StartTime,
+		Annotations: c.getAnnotations(job, cronJob),
+	}
+	if cronJob != nil {
+		messageParam.CronJobSchedule = cronJob.Spec.Schedule
+		if cronJob.Spec.TimeZone != nil {
+			messageParam.CronJobTimeZone = *cronJob.Spec.TimeZone
+		}
+		messageParam.CronJobSuspended = cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend
+		messageParam.LastSuccessfulTime = cronJob.Status.LastSuccessfulTime
 	}
-	return cronJobName, err
+	return messageParam
 }
 
 func getLogMode(annotations map[string]string, annotationName string) logMode {
@@ -393,27 +1444,3 @@ func getPodLogs(clientset kubernetes.Interface, pod corev1.Pod, containerName st
 	}
 	return str
 }
-
-func waitForPodRunning(clientset kubernetes.Interface, pod corev1.Pod) error {
-	pollInterval := 10 * time.Second
-
-	timeout := 20 * time.Minute
-	deadline := time.Now().Add(timeout)
-
-	for {
-		if time.Now().After(deadline) {
-			return fmt.Errorf("timeout waiting for pod to become running")
-		}
-
-		pod, err := clientset.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("error getting pod: %v", err)
-		}
-
-		if pod.Status.Phase != corev1.PodPending {
-			return nil
-		}
-
-		time.Sleep(pollInterval)
-	}
-}
@@ -1,148 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
-	core "k8s.io/client-go/testing"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 	utilpointer "k8s.io/utils/pointer"
 )
 
-func TestIsCompletedJob(t *testing.T) {
-
-	tests := []struct {
-		Name     string
-		job      *batchv1.Job
-		pods     *v1.PodList
-		expected bool
-	}{
-		{
-			"Job is succeeded",
-			&batchv1.Job{
-				ObjectMeta: metav1.ObjectMeta{UID: "test"},
-				Spec:       batchv1.JobSpec{BackoffLimit: utilpointer.Int32Ptr(0)},
-				Status:     batchv1.JobStatus{Succeeded: 1},
-			},
-			&v1.PodList{
-				TypeMeta: metav1.TypeMeta{},
-				ListMeta: metav1.ListMeta{},
-				Items: []v1.Pod{
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-				},
-			},
-			true,
-		},
-		{
-			"Job is completed",
-			&batchv1.Job{
-				ObjectMeta: metav1.ObjectMeta{UID: "test"},
-				Spec:       batchv1.JobSpec{BackoffLimit: utilpointer.Int32Ptr(0)},
-				Status:     batchv1.JobStatus{},
-			},
-			&v1.PodList{
-				TypeMeta: metav1.TypeMeta{},
-				ListMeta: metav1.ListMeta{},
-				Items: []v1.Pod{
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-				},
-			},
-			true,
-		},
-		{
-			"Job is completed when retried",
-			&batchv1.Job{
-				ObjectMeta: metav1.ObjectMeta{UID: "test"},
-				Spec:       batchv1.JobSpec{BackoffLimit: utilpointer.Int32Ptr(3)},
-				Status:     batchv1.JobStatus{},
-			},
-			&v1.PodList{
-				TypeMeta: metav1.TypeMeta{},
-				ListMeta: metav1.ListMeta{},
-				Items: []v1.Pod{
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-				},
-			}, true,
-		},
-		{
-			"Job is not completed",
-			&batchv1.Job{
-				ObjectMeta: metav1.ObjectMeta{UID: "test"},
-				Spec:       batchv1.JobSpec{BackoffLimit: utilpointer.Int32Ptr(3)},
-				Status:     batchv1.JobStatus{},
-			},
-			&v1.PodList{
-				TypeMeta: metav1.TypeMeta{},
-				ListMeta: metav1.ListMeta{},
-				Items: []v1.Pod{
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-					{
-						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{searchLabel: "test"}},
-						Spec:       v1.PodSpec{},
-						Status:     v1.PodStatus{},
-					},
-				},
-			}, false,
-		},
-	}
-
-	for _, test := range tests {
-		t.Run(test.Name, func(t *testing.T) {
-			fakeClient := &fake.Clientset{}
-			fakeClient = addListPodsReactor(fakeClient, test.pods)
-			job := test.job
-
-			result := isCompletedJob(fakeClient, job)
-			if result != test.expected {
-				t.Errorf("In test case %s, expext:%v, got  %v", test.Name, test.expected, result)
-			}
-
-		})
-	}
-}
-
 func TestGetLogMode(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -314,54 +192,293 @@ func TestGetJobLogs(t *testing.T) {
 		})
 	}
 }
-func addListPodsReactor(fakeClient *fake.Clientset, obj runtime.Object) *fake.Clientset {
-	fakeClient.AddReactor("list", "pods", func(action core.Action) (handled bool, ret runtime.Object, err error) {
-		return true, obj, nil
-	})
-	return fakeClient
+func TestGetFinishedCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []batchv1.JobCondition
+		expected   batchv1.JobConditionType
+		succeeded  bool
+	}{
+		{
+			name:       "running",
+			conditions: nil,
+			expected:   "",
+		},
+		{
+			name: "complete",
+			conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobSuccessCriteriaMet, Status: corev1.ConditionTrue},
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+			expected:  batchv1.JobComplete,
+			succeeded: true,
+		},
+		{
+			name: "success criteria met",
+			conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobSuccessCriteriaMet, Status: corev1.ConditionTrue},
+			},
+			expected:  batchv1.JobSuccessCriteriaMet,
+			succeeded: true,
+		},
+		{
+			name: "failed",
+			conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded},
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded},
+			},
+			expected: batchv1.JobFailed,
+		},
+		{
+			name: "failure target",
+			conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonPodFailurePolicy},
+			},
+			expected: batchv1.JobFailureTarget,
+		},
+		{
+			name: "condition not true",
+			conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionFalse},
+			},
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := &batchv1.Job{Status: batchv1.JobStatus{Conditions: test.conditions}}
+			got := getFinishedCondition(job)
+			if test.expected == "" {
+				assert.Nil(t, got)
+				return
+			}
+			assert.Equal(t, test.expected, got.Type)
+			assert.Equal(t, test.succeeded, isSucceededCondition(got.Type))
+		})
+	}
 }
 
-func TestGetPodFromControllerUID(t *testing.T) {
-	backoffLimit := int32(0)
+func TestGetExpectedDuration(t *testing.T) {
+	c := &Controller{config: controllerConfig{defaultExpectedDuration: time.Hour}}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		{"default", nil, time.Hour},
+		{"annotation", map[string]string{expectedDurationAnnotationName: "30m"}, 30 * time.Minute},
+		{"disabled by annotation", map[string]string{expectedDurationAnnotationName: "0"}, 0},
+		{"invalid annotation", map[string]string{expectedDurationAnnotationName: "soon"}, time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, c.getExpectedDuration(&batchv1.Job{}, test.annotations))
+		})
+	}
+}
+
+func TestGetPodStatus(t *testing.T) {
+	assert.Equal(t, "", getPodStatus(nil))
+	assert.Equal(t, "Running", getPodStatus(&corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}}))
+	assert.Equal(t, "Running (app: CrashLoopBackOff)", getPodStatus(&corev1.Pod{Status: corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "app", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		},
+	}}))
+}
+
+func TestGetContainerFailures(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		InitContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "init",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+		}},
+		ContainerStatuses: []corev1.ContainerStatus{
+			{
+				Name: "app",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					Reason:   "OOMKilled",
+					ExitCode: 137,
+					Signal:   9,
+					Message:  "out of memory\n",
+				}},
+			},
+			{
+				Name:  "sidecar",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			},
+		},
+	}}
+	assert.Equal(t, []notification.ContainerFailure{
+		{Name: "app", Reason: "OOMKilled", ExitCode: 137, Signal: 9, Message: "out of memory"},
+	}, getContainerFailures(pod))
+	assert.Empty(t, getContainerFailures(&corev1.Pod{}))
+}
+
+func TestTailLines(t *testing.T) {
+	assert.Equal(t, "", tailLines("", 2))
+	assert.Equal(t, "a\nb", tailLines("a\nb\n", 2))
+	assert.Equal(t, "b\nc", tailLines("a\nb\nc\n", 2))
+}
+
+func TestIsOptedIn(t *testing.T) {
+	enabled := map[string]string{enabledAnnotationName: "true"}
+	disabled := map[string]string{enabledAnnotationName: "false"}
+	namespaces := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "enabled", Annotations: enabled}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	}
+
+	tests := []struct {
+		name     string
+		optIn    bool
+		job      *batchv1.Job
+		cronJob  *batchv1.CronJob
+		expected bool
+	}{
+		{
+			name:     "opt-in disabled",
+			job:      &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}},
+			expected: true,
+		},
+		{
+			name:     "no annotation",
+			optIn:    true,
+			job:      &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}},
+			expected: false,
+		},
+		{
+			name:     "job enabled",
+			optIn:    true,
+			job:      &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: enabled}},
+			expected: true,
+		},
+		{
+			name:  "pod template enabled",
+			optIn: true,
+			job: &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: enabled},
+				}},
+			},
+			expected: true,
+		},
+		{
+			name:     "cronjob enabled",
+			optIn:    true,
+			job:      &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}},
+			cronJob:  &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: enabled}},
+			expected: true,
+		},
+		{
+			name:     "namespace enabled",
+			optIn:    true,
+			cronJob:  &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: "enabled"}},
+			expected: true,
+		},
+		{
+			name:     "job disabled in enabled namespace",
+			optIn:    true,
+			job:      &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "enabled", Annotations: disabled}},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestController(t, nil, namespaces...)
+			c.config.optIn = test.optIn
+			assert.Equal(t, test.expected, c.isOptedIn(c.getAnnotations(test.job, test.cronJob)))
+		})
+	}
+}
+
+func TestGetPodStuckReason(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          corev1.PodStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:   "pending without reason",
+			status: corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Reason:  corev1.PodReasonUnschedulable,
+				Message: "0/12 nodes are available: insufficient memory",
+			}}},
+			expectedReason:  "Unschedulable",
+			expectedMessage: "0/12 nodes are available: insufficient memory",
+		},
+		{
+			name: "init container config error",
+			status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{
+				Name: "init",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+					Reason:  "CreateContainerConfigError",
+					Message: `secret "db" not found`,
+				}},
+			}}},
+			expectedReason:  "CreateContainerConfigError",
+			expectedMessage: `container init: secret "db" not found`,
+		},
+		{
+			name: "container creating",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "app",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason, message := getPodStuckReason(&corev1.Pod{Status: test.status})
+			assert.Equal(t, test.expectedReason, reason)
+			assert.Equal(t, test.expectedMessage, message)
+		})
+	}
+}
+
+func TestGetLatestJobPod(t *testing.T) {
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default", UID: "test-uid"},
-		Spec:       batchv1.JobSpec{BackoffLimit: &backoffLimit},
 	}
+	now := time.Now()
 
-	t.Run("returns last pod when pods exist", func(t *testing.T) {
-		pods := &v1.PodList{
-			Items: []v1.Pod{
-				{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Labels: map[string]string{searchLabel: "test-uid"}}},
-				{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Labels: map[string]string{searchLabel: "test-uid"}}},
-			},
-		}
-		fakeClient := addListPodsReactor(&fake.Clientset{}, pods)
-		got, err := getPodFromControllerUID(fakeClient, job)
+	t.Run("returns latest pod when pods exist", func(t *testing.T) {
+		c, _ := newTestController(t, nil,
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default",
+				CreationTimestamp: metav1.NewTime(now), Labels: map[string]string{jobUIDLabel: "test-uid"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default",
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)), Labels: map[string]string{searchLabel: "test-uid"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other-pod", Namespace: "default",
+				CreationTimestamp: metav1.NewTime(now.Add(time.Minute)), Labels: map[string]string{searchLabel: "other-uid"}}},
+		)
+		got, err := c.getLatestJobPod(job)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if got.Name != "pod-2" {
-			t.Errorf("expected pod-2, got %s", got.Name)
+		if got == nil || got.Name != "pod-2" {
+			t.Errorf("expected pod-2, got %v", got)
 		}
 	})
 
-	t.Run("returns error when pod list is empty", func(t *testing.T) {
-		pods := &v1.PodList{Items: []v1.Pod{}}
-		fakeClient := addListPodsReactor(&fake.Clientset{}, pods)
-		_, err := getPodFromControllerUID(fakeClient, job)
-		if err == nil {
-			t.Fatal("expected error, got nil")
+	t.Run("returns nil when job has no pods", func(t *testing.T) {
+		c, _ := newTestController(t, nil)
+		got, err := c.getLatestJobPod(job)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-	})
-
-	t.Run("returns error on API failure", func(t *testing.T) {
-		fakeClient := &fake.Clientset{}
-		fakeClient.AddReactor("list", "pods", func(action core.Action) (handled bool, ret runtime.Object, err error) {
-			return true, nil, errors.New("api error")
-		})
-		_, err := getPodFromControllerUID(fakeClient, job)
-		if err == nil {
-			t.Fatal("expected error, got nil")
+		if got != nil {
+			t.Errorf("expected nil, got %v", got)
 		}
 	})
 }
@@ -369,21 +486,13 @@ func TestGetPodFromControllerUID(t *testing.T) {
 func TestGetCronJobNameFromOwnerReferences(t *testing.T) {
 	t.Run("returns empty string when no owner references", func(t *testing.T) {
 		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default"}}
-		fakeClient := fake.NewSimpleClientset()
-		got, err := getCronJobNameFromOwnerReferences(fakeClient, job)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
-		}
+		got := getCronJobNameFromOwnerReferences(job)
 		if got != "" {
 			t.Errorf("expected empty string, got %q", got)
 		}
 	})
 
-	t.Run("returns CronJob name when BatchV1 CronJob exists", func(t *testing.T) {
-		cronJob := &batchv1.CronJob{
-			ObjectMeta: metav1.ObjectMeta{Name: "my-cronjob", Namespace: "default"},
-		}
-		fakeClient := fake.NewSimpleClientset(cronJob)
+	t.Run("returns CronJob name from owner reference", func(t *testing.T) {
 		isController := true
 		job := &batchv1.Job{
 			ObjectMeta: metav1.ObjectMeta{
@@ -399,90 +508,573 @@ func TestGetCronJobNameFromOwnerReferences(t *testing.T) {
 				},
 			},
 		}
-		got, err := getCronJobNameFromOwnerReferences(fakeClient, job)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
-		}
+		got := getCronJobNameFromOwnerReferences(job)
 		if got != "my-cronjob" {
 			t.Errorf("expected my-cronjob, got %q", got)
 		}
 	})
+}
 
-	t.Run("returns error when CronJob not found", func(t *testing.T) {
-		fakeClient := fake.NewSimpleClientset()
-		isController := true
-		job := &batchv1.Job{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "missing-cronjob-abc",
-				Namespace: "default",
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						Kind:       "CronJob",
-						Name:       "missing-cronjob",
-						APIVersion: "batch/v1",
-						Controller: &isController,
-					},
-				},
+func TestNewMessageTemplateParam(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "my-cronjob-abc", Namespace: "default"}}
+
+	t.Run("without CronJob", func(t *testing.T) {
+		got := (&Controller{}).newMessageTemplateParam(job, "", nil)
+		assert.Equal(t, "my-cronjob-abc", got.JobName)
+		assert.Empty(t, got.CronJobSchedule)
+		assert.Empty(t, got.ClusterName)
+	})
+
+	t.Run("with cluster name", func(t *testing.T) {
+		c := &Controller{config: controllerConfig{clusterName: "prod-eu"}}
+		got := c.newMessageTemplateParam(job, "", nil)
+		assert.Equal(t, "prod-eu", got.ClusterName)
+	})
+
+	t.Run("with CronJob", func(t *testing.T) {
+		lastSuccessfulTime := metav1.NewTime(time.Date(2020, 11, 28, 1, 2, 3, 0, time.UTC))
+		cronJob := &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-cronjob", Namespace: "default"},
+			Spec: batchv1.CronJobSpec{
+				Schedule: "*/5 * * * *",
+				TimeZone: utilpointer.String("Asia/Tokyo"),
+				Suspend:  utilpointer.Bool(true),
 			},
+			Status: batchv1.CronJobStatus{LastSuccessfulTime: &lastSuccessfulTime},
 		}
-		got, err := getCronJobNameFromOwnerReferences(fakeClient, job)
-		if err == nil {
-			t.Fatal("expected error, got nil")
-		}
-		if got != "" {
-			t.Errorf("expected empty string on error, got %q", got)
-		}
+		got := (&Controller{}).newMessageTemplateParam(job, "my-cronjob", cronJob)
+		assert.Equal(t, "my-cronjob", got.CronJobName)
+		assert.Equal(t, "*/5 * * * *", got.CronJobSchedule)
+		assert.Equal(t, "Asia/Tokyo", got.CronJobTimeZone)
+		assert.True(t, got.CronJobSuspended)
+		assert.Equal(t, &lastSuccessfulTime, got.LastSuccessfulTime)
 	})
 }
 
-func TestWaitForPodRunning(t *testing.T) {
-	t.Run("returns nil when pod is already Running", func(t *testing.T) {
-		pod := corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
-			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+type fakeNotification struct {
+	started, succeeded, failed, missed, longRunning, stuck, retried int
+	stuckReason                                                     string
+	lastStart, lastFailed, lastRetry                                notification.MessageTemplateParam
+	err                                                             error
+	// timestamp and permalink identify the Slack message of the returned
+	// Delivery.
+	timestamp, permalink string
+}
+
+func (f *fakeNotification) deliver() (*notification.Delivery, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &notification.Delivery{Channel: "C123", Timestamp: f.timestamp, Permalink: f.permalink}, nil
+}
+
+func (f *fakeNotification) NotifyStart(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.started++
+	f.lastStart = messageParam
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifySuccess(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.succeeded++
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifyFailed(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.failed++
+	f.lastFailed = messageParam
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifyMissedSchedule(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.missed++
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifyLongRunning(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.longRunning++
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifyStuck(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.stuck++
+	f.stuckReason = messageParam.StuckReason
+	return f.deliver()
+}
+
+func (f *fakeNotification) NotifyRetry(messageParam notification.MessageTemplateParam) (*notification.Delivery, error) {
+	f.retried++
+	f.lastRetry = messageParam
+	return f.deliver()
+}
+
+func newTestController(t *testing.T, job *batchv1.Job, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+	if job != nil {
+		objects = append(objects, job)
+	}
+	fakeClient := fake.NewSimpleClientset(objects...)
+	informerFactory := kubeinformers.NewSharedInformerFactory(fakeClient, 0)
+	jobInformer := informerFactory.Batch().V1().Jobs()
+	cronJobInformer := informerFactory.Batch().V1().CronJobs()
+	podInformer := informerFactory.Core().V1().Pods()
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	c := NewController(fakeClient, jobInformer, cronJobInformer, podInformer, namespaceInformer,
+		controllerConfig{catchUpWindow: time.Hour})
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *batchv1.Job:
+			err = jobInformer.Informer().GetIndexer().Add(o)
+		case *batchv1.CronJob:
+			err = cronJobInformer.Informer().GetIndexer().Add(o)
+		case *corev1.Pod:
+			err = podInformer.Informer().GetIndexer().Add(o)
+		case *corev1.Namespace:
+			err = namespaceInformer.Informer().GetIndexer().Add(o)
 		}
-		fakeClient := fake.NewSimpleClientset(&pod)
-		err := waitForPodRunning(fakeClient, pod)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-	})
+	}
+	return c, fakeClient
+}
 
-	t.Run("returns nil when pod is Succeeded", func(t *testing.T) {
-		pod := corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
-			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
-		}
-		fakeClient := fake.NewSimpleClientset(&pod)
-		err := waitForPodRunning(fakeClient, pod)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+func TestNewRateLimiter(t *testing.T) {
+	limiter := newRateLimiter()
+	var total time.Duration
+	for i := 0; i < maxRetries; i++ {
+		delay := limiter.When("default/test-job")
+		if i == 0 {
+			assert.Equal(t, retryBaseDelay, delay)
 		}
+		assert.LessOrEqual(t, delay, retryMaxDelay)
+		total += delay
+	}
+	assert.Greater(t, total, 30*time.Minute, "retries ride out sink outages")
+
+	limiter.Forget("default/test-job")
+	assert.Equal(t, retryBaseDelay, limiter.When("default/test-job"))
+}
+
+func TestSyncHandler(t *testing.T) {
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-job",
+			Namespace:         "default",
+			UID:               "test-uid",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+		},
+		Spec: batchv1.JobSpec{BackoffLimit: &backoffLimit},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-job-pod",
+			Namespace: "default",
+			Labels:    map[string]string{searchLabel: "test-uid"},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	t.Run("sends start notification only once", func(t *testing.T) {
+		c, _ := newTestController(t, job, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, 0, n.succeeded)
+		assert.Equal(t, 0, n.failed)
 	})
 
-	t.Run("returns nil when pod is Failed", func(t *testing.T) {
-		pod := corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
-			Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+	t.Run("skips job not matching filter", func(t *testing.T) {
+		c, _ := newTestController(t, job, pod)
+		filter, err := newJobFilter(`has(job.metadata.labels) && job.metadata.labels.team == "billing"`)
+		assert.NoError(t, err)
+		c.filter = filter
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+	})
+
+	t.Run("routes with namespace default annotations", func(t *testing.T) {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{"kube-job-notifier/default-channel": "team-channel"},
+		}}
+		c, _ := newTestController(t, job, pod, namespace)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, "team-channel", n.lastStart.Annotations["kube-job-notifier/default-channel"])
+	})
+
+	t.Run("waits for pod to leave pending", func(t *testing.T) {
+		pendingPod := pod.DeepCopy()
+		pendingPod.Status.Phase = corev1.PodPending
+		c, _ := newTestController(t, job, pendingPod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+	})
+
+	t.Run("notifies each failed attempt once when retries are enabled", func(t *testing.T) {
+		limit := int32(5)
+		retryJob := job.DeepCopy()
+		retryJob.Spec.BackoffLimit = &limit
+		retryJob.Spec.Template.Annotations = map[string]string{notifyRetriesAnnotationName: "true"}
+		retryJob.Status.Failed = 1
+		failedPod := pod.DeepCopy()
+		failedPod.Name = "test-job-failed"
+		failedPod.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+		failedPod.Status.Phase = corev1.PodFailed
+		failedPod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "app",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2}},
+		}}
+		runningPod := pod.DeepCopy()
+		runningPod.CreationTimestamp = metav1.NewTime(time.Now())
+		c, fakeClient := newTestController(t, retryJob, failedPod, runningPod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.retried)
+		assert.Equal(t, 2, n.lastRetry.Attempt)
+		assert.Equal(t, 6, n.lastRetry.MaxAttempts)
+		assert.Equal(t, []notification.ContainerFailure{{Name: "app", ExitCode: 2}}, n.lastRetry.ContainerFailures)
+
+		updated, err := fakeClient.BatchV1().Jobs("default").Get(context.TODO(), "test-job", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "start,retry-1", updated.Annotations[notifiedEventsAnnotationName])
+	})
+
+	t.Run("does not notify failed attempts without opt-in", func(t *testing.T) {
+		retryJob := job.DeepCopy()
+		retryJob.Status.Failed = 1
+		c, _ := newTestController(t, retryJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.retried)
+	})
+
+	t.Run("notifies job running longer than expected once", func(t *testing.T) {
+		longJob := job.DeepCopy()
+		longJob.Spec.Template.Annotations = map[string]string{expectedDurationAnnotationName: "30m"}
+		longJob.Status.StartTime = &metav1.Time{Time: time.Now().Add(-45 * time.Minute)}
+		c, fakeClient := newTestController(t, longJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, 1, n.longRunning)
+		got, err := fakeClient.BatchV1().Jobs("default").Get(context.TODO(), "test-job", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "start,long-running", got.Annotations[notifiedEventsAnnotationName])
+	})
+
+	t.Run("does not notify job running within expected duration", func(t *testing.T) {
+		shortJob := job.DeepCopy()
+		shortJob.Status.StartTime = &metav1.Time{Time: time.Now().Add(-10 * time.Minute)}
+		c, _ := newTestController(t, shortJob, pod)
+		c.config.defaultExpectedDuration = 30 * time.Minute
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, 0, n.longRunning)
+	})
+
+	t.Run("notifies job with pod stuck pending once", func(t *testing.T) {
+		stuckPod := pod.DeepCopy()
+		stuckPod.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+		stuckPod.Status.Phase = corev1.PodPending
+		stuckPod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "app",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+		}}
+		c, _ := newTestController(t, job, stuckPod)
+		c.config.stuckPodThreshold = 10 * time.Minute
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+		assert.Equal(t, 1, n.stuck)
+		assert.Equal(t, "ImagePullBackOff", n.stuckReason)
+	})
+
+	t.Run("does not notify pod pending within stuck threshold", func(t *testing.T) {
+		stuckPod := pod.DeepCopy()
+		stuckPod.CreationTimestamp = metav1.NewTime(time.Now())
+		stuckPod.Status.Phase = corev1.PodPending
+		stuckPod.Status.Conditions = []corev1.PodCondition{{
+			Type:   corev1.PodScheduled,
+			Status: corev1.ConditionFalse,
+			Reason: corev1.PodReasonUnschedulable,
+		}}
+		c, _ := newTestController(t, job, stuckPod)
+		c.config.stuckPodThreshold = 10 * time.Minute
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.stuck)
+	})
+
+	t.Run("sends success notification once job succeeded", func(t *testing.T) {
+		succeededJob := job.DeepCopy()
+		succeededJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		c, _ := newTestController(t, succeededJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, 1, n.succeeded)
+		assert.Equal(t, 0, n.failed)
+	})
+
+	t.Run("does not treat retried pod failures as job failure", func(t *testing.T) {
+		retryingJob := job.DeepCopy()
+		retryingJob.Status.Failed = 2
+		c, _ := newTestController(t, retryingJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, 0, n.failed)
+	})
+
+	t.Run("retries only the sinks that failed", func(t *testing.T) {
+		failedJob := job.DeepCopy()
+		failedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		c, _ := newTestController(t, failedJob, pod)
+		ok := &fakeNotification{}
+		broken := &fakeNotification{err: errors.New("webhook error")}
+		c.notifications = map[string]notification.Notification{"ok": ok, "broken": broken}
+
+		assert.Error(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, ok.started)
+		assert.Equal(t, 0, ok.failed)
+
+		broken.err = nil
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, ok.started)
+		assert.Equal(t, 1, ok.failed)
+		assert.Equal(t, 2, broken.started)
+		assert.Equal(t, 1, broken.failed)
+	})
+
+	t.Run("returns failure notification errors", func(t *testing.T) {
+		cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "test-cronjob", Namespace: "default"}}
+		failedJob := job.DeepCopy()
+		failedJob.OwnerReferences = []metav1.OwnerReference{{Kind: "CronJob", Name: "test-cronjob"}}
+		failedJob.Annotations = map[string]string{notifiedEventsAnnotationName: "start"}
+		failedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		c, fakeClient := newTestController(t, failedJob, pod, cronJob)
+		broken := &fakeNotification{err: errors.New("webhook error")}
+		c.notifications = map[string]notification.Notification{"broken": broken}
+
+		assert.ErrorContains(t, c.syncHandler("default/test-job"), "webhook error")
+		assert.Equal(t, 1, broken.failed)
+		assert.False(t, c.notifiedJobs.IsDone("test-uid", eventFailed))
+		got, err := fakeClient.BatchV1().CronJobs("default").Get(context.TODO(), "test-cronjob", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, got.Annotations[lastResultAnnotationName], "the result is recorded once the Job is notified")
+	})
+
+	t.Run("retries recording notified events", func(t *testing.T) {
+		succeededJob := job.DeepCopy()
+		succeededJob.Annotations = map[string]string{notifiedEventsAnnotationName: "start"}
+		succeededJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		c, fakeClient := newTestController(t, succeededJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+		patches := 0
+		fakeClient.PrependReactor("patch", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			patches++
+			if patches == 1 {
+				return true, nil, errors.New("etcdserver: request timed out")
+			}
+			return false, nil, nil
+		})
+
+		assert.ErrorContains(t, c.syncHandler("default/test-job"), "request timed out")
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 1, n.succeeded)
+		assert.Equal(t, 2, patches, "the write is retried once")
+		got, err := fakeClient.BatchV1().Jobs("default").Get(context.TODO(), "test-job", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "start,success", got.Annotations[notifiedEventsAnnotationName])
+	})
+
+	t.Run("records notification outcomes as events on the job", func(t *testing.T) {
+		c, _ := newTestController(t, job, pod)
+		recorder := record.NewFakeRecorder(10)
+		c.recorder = recorder
+		c.notifications = map[string]notification.Notification{
+			"slack":  &fakeNotification{timestamp: "1712345678.000100"},
+			"broken": &fakeNotification{err: errors.New("webhook error")},
 		}
-		fakeClient := fake.NewSimpleClientset(&pod)
-		err := waitForPodRunning(fakeClient, pod)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+
+		assert.Error(t, c.syncHandler("default/test-job"))
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
 		}
+		assert.ElementsMatch(t, []string{
+			"Normal NotificationSent Sent start notification to slack channel C123 (ts 1712345678.000100)",
+			"Warning NotificationFailed Failed to send start notification to broken: webhook error",
+		}, events)
 	})
 
-	t.Run("returns error when pod get fails", func(t *testing.T) {
-		pod := corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
-		}
-		fakeClient := &fake.Clientset{}
-		fakeClient.AddReactor("get", "pods", func(action core.Action) (handled bool, ret runtime.Object, err error) {
-			return true, nil, errors.New("api error")
-		})
-		err := waitForPodRunning(fakeClient, pod)
-		if err == nil {
-			t.Fatal("expected error, got nil")
+	t.Run("skips events older than catch-up window", func(t *testing.T) {
+		oldJob := job.DeepCopy()
+		oldJob.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		oldJob.Status.Conditions = []batchv1.JobCondition{{
+			Type:               batchv1.JobFailed,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+		}}
+		c, _ := newTestController(t, oldJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+		assert.Equal(t, 0, n.failed)
+	})
+
+	t.Run("catches up on jobs finished within catch-up window", func(t *testing.T) {
+		recentJob := job.DeepCopy()
+		recentJob.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		recentJob.Status.Conditions = []batchv1.JobCondition{{
+			Type:               batchv1.JobFailed,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+		}}
+		c, _ := newTestController(t, recentJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+		assert.Equal(t, 1, n.failed)
+	})
+
+	t.Run("records notified events on the job", func(t *testing.T) {
+		succeededJob := job.DeepCopy()
+		succeededJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		c, fakeClient := newTestController(t, succeededJob, pod)
+		c.notifications = map[string]notification.Notification{"fake": &fakeNotification{}}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		got, err := fakeClient.BatchV1().Jobs("default").Get(context.TODO(), "test-job", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "start,success", got.Annotations[notifiedEventsAnnotationName])
+	})
+
+	t.Run("records delivery status on the job", func(t *testing.T) {
+		succeededJob := job.DeepCopy()
+		succeededJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		c, fakeClient := newTestController(t, succeededJob, pod)
+		c.notifications = map[string]notification.Notification{
+			"slack": &fakeNotification{
+				timestamp: "1712345678.000100",
+				permalink: "https://example.slack.com/archives/C123/p1",
+			},
+			"msteamsv2": &fakeNotification{},
 		}
+		// Deliveries recorded before a restart are kept.
+		c.notifiedJobs.Load("test-uid", nil, deliveryStatus{sinks: []string{"datadog"}})
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		got, err := fakeClient.BatchV1().Jobs("default").Get(context.TODO(), "test-job", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "datadog,msteamsv2,slack", got.Annotations[notifiedSinksAnnotationName])
+		assert.Equal(t, "https://example.slack.com/archives/C123/p1", got.Annotations[slackPermalinkAnnotationName])
+		notifiedAt, err := time.Parse(time.RFC3339, got.Annotations[notifiedAtAnnotationName])
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), notifiedAt, time.Minute)
+	})
+
+	t.Run("records result streak on the cronjob", func(t *testing.T) {
+		cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cronjob",
+			Namespace: "default",
+			Annotations: map[string]string{
+				lastJobAnnotationName:              "test-cronjob-1",
+				lastResultAnnotationName:           "failed",
+				consecutiveFailuresAnnotationName:  "2",
+				consecutiveSuccessesAnnotationName: "0",
+			},
+		}}
+		failedJob := job.DeepCopy()
+		failedJob.OwnerReferences = []metav1.OwnerReference{{Kind: "CronJob", Name: "test-cronjob"}}
+		failedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		c, fakeClient := newTestController(t, failedJob, pod, cronJob)
+		c.notifications = map[string]notification.Notification{"fake": &fakeNotification{}}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		got, err := fakeClient.BatchV1().CronJobs("default").Get(context.TODO(), "test-cronjob", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "test-job", got.Annotations[lastJobAnnotationName])
+		assert.Equal(t, "failed", got.Annotations[lastResultAnnotationName])
+		assert.Equal(t, "3", got.Annotations[consecutiveFailuresAnnotationName])
+		assert.Equal(t, "0", got.Annotations[consecutiveSuccessesAnnotationName])
+
+		// The same Job is counted once.
+		c.recordCronJobResult(cronJob, failedJob, true)
+		got, err = fakeClient.BatchV1().CronJobs("default").Get(context.TODO(), "test-cronjob", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "3", got.Annotations[consecutiveFailuresAnnotationName])
+
+		succeededJob := failedJob.DeepCopy()
+		succeededJob.Name = "test-job-2"
+		c.recordCronJobResult(cronJob, succeededJob, true)
+		got, err = fakeClient.BatchV1().CronJobs("default").Get(context.TODO(), "test-cronjob", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "success", got.Annotations[lastResultAnnotationName])
+		assert.Equal(t, "0", got.Annotations[consecutiveFailuresAnnotationName])
+		assert.Equal(t, "1", got.Annotations[consecutiveSuccessesAnnotationName])
+	})
+
+	t.Run("does not resend events recorded on the job", func(t *testing.T) {
+		succeededJob := job.DeepCopy()
+		succeededJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		succeededJob.Annotations = map[string]string{notifiedEventsAnnotationName: "start,success"}
+		c, _ := newTestController(t, succeededJob, pod)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
+		assert.Equal(t, 0, n.succeeded)
+	})
+
+	t.Run("ignores deleted jobs", func(t *testing.T) {
+		c, _ := newTestController(t, nil)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		assert.NoError(t, c.syncHandler("default/test-job"))
+		assert.Equal(t, 0, n.started)
 	})
 }
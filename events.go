@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// getJobEvents returns the Kubernetes Events of the Job and its Pods for a
+// failure notification, so that the context is kept after the Events expire.
+// Events are deduplicated by reason, keeping the most recent one and adding
+// up the counts, and at most config.failureEvents are returned, newest first.
+func (c *Controller) getJobEvents(job *batchv1.Job) []notification.JobEvent {
+	if c.config.failureEvents == 0 {
+		return nil
+	}
+
+	uids := []types.UID{job.UID}
+	objs, err := c.podsIndexer.ByIndex(jobUIDIndex, string(job.UID))
+	if err != nil {
+		klog.Errorf("Get pods failed: %v", err)
+	}
+	for _, obj := range objs {
+		uids = append(uids, obj.(*corev1.Pod).UID)
+	}
+
+	byReason := make(map[string]*notification.JobEvent)
+	for _, uid := range uids {
+		events, err := c.kubeclientset.CoreV1().Events(job.Namespace).List(context.TODO(), metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(uid)).String(),
+		})
+		if err != nil {
+			klog.Errorf("List events of job %s/%s failed: %v", job.Namespace, job.Name, err)
+			continue
+		}
+		for _, e := range events.Items {
+			count := e.Count
+			if count == 0 {
+				count = 1
+			}
+			lastSeen := getEventTime(e)
+			existing, ok := byReason[e.Reason]
+			if ok {
+				count += existing.Count
+				if !lastSeen.After(existing.LastSeen) {
+					existing.Count = count
+					continue
+				}
+			}
+			byReason[e.Reason] = &notification.JobEvent{
+				Type:     e.Type,
+				Reason:   e.Reason,
+				Object:   e.InvolvedObject.Kind + "/" + e.InvolvedObject.Name,
+				Message:  e.Message,
+				Count:    count,
+				LastSeen: lastSeen,
+			}
+		}
+	}
+
+	jobEvents := make([]notification.JobEvent, 0, len(byReason))
+	for _, e := range byReason {
+		jobEvents = append(jobEvents, *e)
+	}
+	sort.Slice(jobEvents, func(i, j int) bool {
+		return jobEvents[i].LastSeen.After(jobEvents[j].LastSeen)
+	})
+	if len(jobEvents) > c.config.failureEvents {
+		jobEvents = jobEvents[:c.config.failureEvents]
+	}
+	return jobEvents
+}
+
+// getEventTime returns when the Event was last seen. Events created through
+// the events.k8s.io API only set EventTime.
+func getEventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+// redactedURL replaces URLs in errors, see redactURL.
+const redactedURL = "<redacted>"
+
+// redactURL returns err without the URL of the failed request if it wraps a
+// *url.Error, as returned by HTTP clients, so that the URL is not written to
+// Events and logs.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || urlErr.URL == "" {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), urlErr.URL, redactedURL))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGetJobEvents(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default", UID: "job-uid"},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-job-pod",
+			Namespace: "default",
+			UID:       "pod-uid",
+			Labels:    map[string]string{jobUIDLabel: "job-uid"},
+		},
+	}
+	newEvent := func(name string, object corev1.ObjectReference, eventType, reason, message string, count int32, age time.Duration) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+			InvolvedObject: object,
+			Type:           eventType,
+			Reason:         reason,
+			Message:        message,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(now.Add(-age)),
+		}
+	}
+	jobRef := corev1.ObjectReference{Kind: "Job", Name: "test-job", UID: "job-uid"}
+	podRef := corev1.ObjectReference{Kind: "Pod", Name: "test-job-pod", UID: "pod-uid"}
+	otherRef := corev1.ObjectReference{Kind: "Pod", Name: "other", UID: "other-uid"}
+
+	c, fakeClient := newTestController(t, job, pod,
+		newEvent("e1", podRef, "Warning", "BackOff", "Back-off restarting failed container", 3, 5*time.Minute),
+		newEvent("e2", podRef, "Warning", "BackOff", "Back-off restarting failed container app", 2, time.Minute),
+		newEvent("e3", podRef, "Normal", "Pulled", "Successfully pulled image", 1, 10*time.Minute),
+		newEvent("e4", jobRef, "Warning", "BackoffLimitExceeded", "Job has reached the specified backoff limit", 1, 0),
+		newEvent("e5", otherRef, "Warning", "FailedMount", "unrelated", 1, 0),
+	)
+	// The fake clientset ignores field selectors, so filter by involvedObject.uid here.
+	fakeClient.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Fields
+		obj, err := fakeClient.Tracker().List(corev1.SchemeGroupVersion.WithResource("events"),
+			corev1.SchemeGroupVersion.WithKind("Event"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*corev1.EventList)
+		var items []corev1.Event
+		for _, e := range list.Items {
+			if selector.Matches(fields.Set{"involvedObject.uid": string(e.InvolvedObject.UID)}) {
+				items = append(items, e)
+			}
+		}
+		list.Items = items
+		return true, list, nil
+	})
+
+	t.Run("deduplicates by reason and keeps the most recent", func(t *testing.T) {
+		c.config.failureEvents = 2
+		assert.Equal(t, []notification.JobEvent{
+			{
+				Type:     "Warning",
+				Reason:   "BackoffLimitExceeded",
+				Object:   "Job/test-job",
+				Message:  "Job has reached the specified backoff limit",
+				Count:    1,
+				LastSeen: now,
+			},
+			{
+				Type:     "Warning",
+				Reason:   "BackOff",
+				Object:   "Pod/test-job-pod",
+				Message:  "Back-off restarting failed container app",
+				Count:    5,
+				LastSeen: now.Add(-time.Minute),
+			},
+		}, c.getJobEvents(job))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c.config.failureEvents = 0
+		assert.Empty(t, c.getJobEvents(job))
+	})
+}
+
+func TestGetEventTime(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, now, getEventTime(corev1.Event{LastTimestamp: metav1.NewTime(now)}))
+	assert.Equal(t, now, getEventTime(corev1.Event{EventTime: metav1.NewMicroTime(now)}))
+	assert.Equal(t, now, getEventTime(corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now)}}))
+}
+
+func TestRedactURL(t *testing.T) {
+	urlErr := &url.Error{Op: "Post", URL: "https://example.webhook.office.com/webhook/secret-token", Err: errors.New("connection refused")}
+	assert.EqualError(t, redactURL(urlErr), `Post "<redacted>": connection refused`)
+	assert.EqualError(t, redactURL(fmt.Errorf("send: %w", urlErr)), `send: Post "<redacted>": connection refused`)
+
+	err := errors.New("webhook returned HTTP status 500")
+	assert.Equal(t, err, redactURL(err))
+
+	t.Run("failure events", func(t *testing.T) {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default", UID: "test-uid"}}
+		c, _ := newTestController(t, job)
+		recorder := record.NewFakeRecorder(10)
+		c.recorder = recorder
+		c.notifications = map[string]notification.Notification{"msteamsv2": &fakeNotification{err: urlErr}}
+
+		err := c.notify(job, "test-uid", eventStart, notification.MessageTemplateParam{JobName: job.Name},
+			notification.Notification.NotifyStart, monitoring.JobInfo{}, nil)
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), "secret-token")
+		assert.Equal(t, `Warning NotificationFailed Failed to send start notification to msteamsv2: Post "<redacted>": connection refused`,
+			<-recorder.Events)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// jobFilter is a CEL expression deciding whether a Job is notified, e.g.
+// `job.metadata.labels.team == "billing" && job.status.failed > 0`. The
+// expression sees the Job as `job` and its owning CronJob as `cronJob`, which
+// is an empty map for Jobs without a CronJob owner. Optional field access,
+// e.g. `job.metadata.labels.?team.orValue("")`, is enabled.
+type jobFilter struct {
+	expression string
+	program    cel.Program
+}
+
+// newJobFilter compiles the expression and checks that it returns a bool.
+func newJobFilter(expression string) (*jobFilter, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("job", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("cronJob", cel.MapType(cel.StringType, cel.DynType)),
+		cel.OptionalTypes(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expression, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid expression %q: must return bool, not %s", expression, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expression, err)
+	}
+	return &jobFilter{expression: expression, program: program}, nil
+}
+
+// jobStatusCounters are the counters of the Job status, which are omitted
+// by the API while zero.
+var jobStatusCounters = []string{"active", "succeeded", "failed", "ready", "terminating"}
+
+// Matches evaluates the expression against the Job and its CronJob, which may
+// be nil. The labels and annotations of both are empty maps and the counters
+// of the Job status are zero when not set. Accessing any other field that is
+// not set is an error, so optional fields should be tested with has() first.
+func (f *jobFilter) Matches(job *batchv1.Job, cronJob *batchv1.CronJob) (bool, error) {
+	jobObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return false, err
+	}
+	setDefaultMetadata(jobObj)
+	status := setDefault(jobObj, "status", map[string]any{}).(map[string]any)
+	for _, counter := range jobStatusCounters {
+		setDefault(status, counter, int64(0))
+	}
+	cronJobObj := map[string]any{}
+	if cronJob != nil {
+		cronJobObj, err = runtime.DefaultUnstructuredConverter.ToUnstructured(cronJob)
+		if err != nil {
+			return false, err
+		}
+		setDefaultMetadata(cronJobObj)
+	}
+	out, _, err := f.program.Eval(map[string]any{"job": jobObj, "cronJob": cronJobObj})
+	if err != nil {
+		return false, fmt.Errorf("evaluate %q: %w", f.expression, err)
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("evaluate %q: got %v, want bool", f.expression, out.Value())
+	}
+	return matched, nil
+}
+
+// setDefaultMetadata sets the labels and annotations of obj to empty maps
+// if they are not set.
+func setDefaultMetadata(obj map[string]any) {
+	metadata := setDefault(obj, "metadata", map[string]any{}).(map[string]any)
+	setDefault(metadata, "labels", map[string]any{})
+	setDefault(metadata, "annotations", map[string]any{})
+}
+
+// setDefault sets obj[key] to value unless it is set, and returns obj[key].
+func setDefault(obj map[string]any, key string, value any) any {
+	if v, ok := obj[key]; ok && v != nil {
+		return v
+	}
+	obj[key] = value
+	return value
+}
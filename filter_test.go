@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewJobFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expectErr  string
+	}{
+		{name: "valid", expression: `job.metadata.labels.team == "billing"`},
+		{name: "syntax error", expression: `job.metadata.labels.team ==`, expectErr: "invalid expression"},
+		{name: "undeclared variable", expression: `pod.metadata.name == "x"`, expectErr: "undeclared reference"},
+		{name: "not bool", expression: `job.metadata.name`, expectErr: "must return bool"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newJobFilter(test.expression)
+			if test.expectErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.expectErr)
+			}
+		})
+	}
+}
+
+func TestJobFilterMatches(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-job",
+			Labels: map[string]string{"team": "billing"},
+		},
+		Status: batchv1.JobStatus{Failed: 1},
+	}
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cronjob"},
+		Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *"},
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		job        *batchv1.Job
+		cronJob    *batchv1.CronJob
+		expected   bool
+		expectErr  bool
+	}{
+		{
+			name:       "job fields",
+			expression: `job.metadata.labels.team == "billing" && job.status.failed > 0`,
+			expected:   true,
+		},
+		{
+			name:       "cronjob fields",
+			expression: `cronJob.spec.schedule == "0 * * * *"`,
+			cronJob:    cronJob,
+			expected:   true,
+		},
+		{
+			name:       "no cronjob",
+			expression: `!has(cronJob.metadata)`,
+			expected:   true,
+		},
+		{
+			name:       "unset status counter",
+			expression: `job.status.succeeded > 0`,
+			expected:   false,
+		},
+		{
+			name:       "unset status",
+			expression: `job.metadata.labels.team == "billing" && job.status.failed > 0`,
+			job:        &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "started", Labels: map[string]string{"team": "billing"}}},
+			expected:   false,
+		},
+		{
+			name:       "unset labels",
+			expression: `"team" in job.metadata.labels || "team" in cronJob.metadata.labels`,
+			job:        &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+			cronJob:    cronJob,
+			expected:   false,
+		},
+		{
+			name:       "optional field",
+			expression: `job.metadata.labels.?owner.orValue("none") == "none"`,
+			expected:   true,
+		},
+		{
+			name:       "missing field",
+			expression: `job.status.startTime != ""`,
+			expectErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newJobFilter(test.expression)
+			assert.NoError(t, err)
+			j := job
+			if test.job != nil {
+				j = test.job
+			}
+			matched, err := f.Matches(j, test.cronJob)
+			assert.Equal(t, test.expectErr, err != nil)
+			assert.Equal(t, test.expected, matched)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+// leaderElectionTolerance is how long the Lease held by this replica may be
+// expired before the replica is reported as not ready.
+const leaderElectionTolerance = 20 * time.Second
+
+// healthChecks holds the state reported by the /healthz and /readyz
+// endpoints, see serveHealthProbes.
+var healthChecks = newHealthChecker()
+
+// healthChecker reports whether the controllers run and can deliver
+// notifications. Controllers are only registered while this replica leads,
+// so a standby replica is alive and ready as long as it takes part in the
+// election.
+type healthChecker struct {
+	mu          sync.Mutex
+	controllers []*Controller
+	// leaderElection is nil unless leader election is enabled.
+	leaderElection *leaderelection.HealthzAdaptor
+	leading        bool
+	// deliveryThreshold is how long a sink may fail before the replica is
+	// reported as not ready. Zero disables the check.
+	deliveryThreshold time.Duration
+	// lastSuccess maps each sink to the time of its last successful
+	// delivery, and failingSince and lastFailure to the times of its first
+	// and latest failed deliveries after that, for sinks whose latest
+	// delivery failed.
+	lastSuccess  map[string]time.Time
+	failingSince map[string]time.Time
+	lastFailure  map[string]time.Time
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{
+		lastSuccess:  make(map[string]time.Time),
+		failingSince: make(map[string]time.Time),
+		lastFailure:  make(map[string]time.Time),
+	}
+}
+
+// register adds the controller to the checks.
+func (h *healthChecker) register(c *Controller) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.controllers = append(h.controllers, c)
+}
+
+// setLeading records whether this replica holds the Lease.
+func (h *healthChecker) setLeading(leading bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leading = leading
+}
+
+// recordDelivery records the outcome of a delivery to the sink.
+func (h *healthChecker) recordDelivery(sink string, err error, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.lastSuccess[sink] = at
+		delete(h.failingSince, sink)
+		delete(h.lastFailure, sink)
+		return
+	}
+	if _, ok := h.failingSince[sink]; !ok {
+		h.failingSince[sink] = at
+	}
+	h.lastFailure[sink] = at
+}
+
+// healthCheck is the result of a named check. err is nil if it passed.
+type healthCheck struct {
+	name string
+	err  error
+}
+
+// healthz checks that every controller runs and none of its informers
+// stopped.
+func (h *healthChecker) healthz() []healthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var errs []string
+	for _, c := range h.controllers {
+		if !c.running.Load() {
+			errs = append(errs, fmt.Sprintf("controller of cluster %q is not running", c.config.clusterName))
+			continue
+		}
+		for _, resource := range slices.Sorted(maps.Keys(c.informers)) {
+			if c.informers[resource].IsStopped() {
+				errs = append(errs, fmt.Sprintf("%s informer of cluster %q stopped", resource, c.config.clusterName))
+			}
+		}
+	}
+	return []healthCheck{{name: "informers", err: joinErrors(errs)}}
+}
+
+// readyz checks that the caches of every controller synced, that the Lease
+// held by this replica is renewed, and that no sink has been failing for
+// longer than the delivery threshold. A sink that has not been used within
+// the threshold since it failed is not failing, e.g. when the failed
+// notification was dropped or its route removed.
+func (h *healthChecker) readyz(now time.Time) []healthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var unsynced []string
+	for _, c := range h.controllers {
+		if !c.synced.Load() {
+			unsynced = append(unsynced, fmt.Sprintf("caches of cluster %q did not sync", c.config.clusterName))
+		}
+	}
+	checks := []healthCheck{{name: "caches", err: joinErrors(unsynced)}}
+
+	if h.leaderElection != nil {
+		checks = append(checks, healthCheck{name: "leader-election", err: h.leaderElection.Check(nil)})
+	}
+
+	var failing []string
+	if h.deliveryThreshold > 0 {
+		for _, sink := range slices.Sorted(maps.Keys(h.failingSince)) {
+			if now.Sub(h.failingSince[sink]) <= h.deliveryThreshold ||
+				now.Sub(h.lastFailure[sink]) > h.deliveryThreshold {
+				continue
+			}
+			lastSuccess := "never"
+			if t, ok := h.lastSuccess[sink]; ok {
+				lastSuccess = t.UTC().Format(time.RFC3339)
+			}
+			failing = append(failing, fmt.Sprintf("%s failing since %s, last success %s",
+				sink, h.failingSince[sink].UTC().Format(time.RFC3339), lastSuccess))
+		}
+	}
+	return append(checks, healthCheck{name: "deliveries", err: joinErrors(failing)})
+}
+
+// role describes whether this replica sends notifications.
+func (h *healthChecker) role() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.leaderElection == nil || h.leading {
+		return "leader"
+	}
+	return "standby"
+}
+
+// handler serves the checks, with status 500 if any of them failed. The
+// body lists every check, as the probes of the Kubernetes components do.
+func (h *healthChecker) handler(checks func() []healthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		failed := false
+		for _, check := range checks() {
+			if check.err != nil {
+				failed = true
+				fmt.Fprintf(&b, "[-]%s failed: %v\n", check.name, check.err)
+				continue
+			}
+			fmt.Fprintf(&b, "[+]%s ok\n", check.name)
+		}
+		fmt.Fprintf(&b, "role: %s\n", h.role())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, b.String())
+	})
+}
+
+// serveHealthProbes serves /healthz and /readyz on addr until stopCh is
+// closed.
+func serveHealthProbes(addr string, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthChecks.handler(healthChecks.healthz))
+	mux.Handle("/readyz", healthChecks.handler(func() []healthCheck {
+		return healthChecks.readyz(time.Now())
+	}))
+	serveHTTP("health probes", addr, mux, stopCh)
+}
+
+func joinErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
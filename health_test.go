@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckerHealthz(t *testing.T) {
+	c, _ := newTestController(t, nil)
+	c.config.clusterName = "prod"
+	h := newHealthChecker()
+	assert.NoError(t, h.healthz()[0].err, "a standby replica without controllers is alive")
+
+	h.register(c)
+	assert.EqualError(t, h.healthz()[0].err, `controller of cluster "prod" is not running`)
+
+	c.running.Store(true)
+	assert.NoError(t, h.healthz()[0].err)
+}
+
+func TestHealthCheckerReadyz(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c, _ := newTestController(t, nil)
+	h := newHealthChecker()
+	h.deliveryThreshold = time.Hour
+	h.register(c)
+
+	checks := h.readyz(now)
+	assert.Equal(t, "caches", checks[0].name)
+	assert.Error(t, checks[0].err)
+	c.synced.Store(true)
+	assert.NoError(t, h.readyz(now)[0].err)
+
+	h.recordDelivery("slack", nil, now.Add(-3*time.Hour))
+	h.recordDelivery("slack", errors.New("invalid_auth"), now.Add(-2*time.Hour))
+	h.recordDelivery("slack", errors.New("invalid_auth"), now.Add(-time.Minute))
+	h.recordDelivery("msteamsv2", errors.New("timeout"), now.Add(-time.Minute))
+	checks = h.readyz(now)
+	assert.Equal(t, "deliveries", checks[1].name)
+	assert.EqualError(t, checks[1].err, "slack failing since 2024-05-01T10:00:00Z, last success 2024-05-01T09:00:00Z",
+		"sinks failing for less than the threshold are ready")
+
+	h.recordDelivery("slack", nil, now)
+	assert.NoError(t, h.readyz(now)[1].err)
+
+	h.deliveryThreshold = 0
+	h.recordDelivery("slack", errors.New("invalid_auth"), now.Add(-2*time.Hour))
+	assert.NoError(t, h.readyz(now)[1].err)
+
+	h.deliveryThreshold = time.Hour
+	h.recordDelivery("webhook", errors.New("unavailable"), now.Add(-2*time.Hour))
+	assert.NoError(t, h.readyz(now)[1].err, "sinks that failed once and were not used since are ready")
+	h.recordDelivery("webhook", errors.New("unavailable"), now.Add(-time.Minute))
+	assert.EqualError(t, h.readyz(now)[1].err, "webhook failing since 2024-05-01T10:00:00Z, last success never")
+}
+
+func TestHealthCheckerHandler(t *testing.T) {
+	c, _ := newTestController(t, nil)
+	h := newHealthChecker()
+	h.register(c)
+	handler := h.handler(func() []healthCheck { return h.readyz(time.Now()) })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), `[-]caches failed: caches of cluster "" did not sync`)
+
+	c.synced.Store(true)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[+]caches ok\n[+]deliveries ok\nrole: leader\n", rec.Body.String())
+}
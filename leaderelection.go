@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+// leaderElectionConfig holds the settings of the coordination.k8s.io Lease
+// used to elect the single replica that sends notifications.
+type leaderElectionConfig struct {
+	enabled       bool
+	leaseName     string
+	namespace     string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	// watchDog reports to the readiness probe whether the Lease held by
+	// this replica is renewed. It may be nil.
+	watchDog *leaderelection.HealthzAdaptor
+}
+
+// runWithLeaderElection blocks until stopCh is closed. Only while this
+// replica holds the Lease is run called, so that several replicas never send
+// the same notification twice. A replica that loses the Lease exits so that
+// it restarts as a standby with clean informer state.
+func runWithLeaderElection(kubeclientset kubernetes.Interface, config leaderElectionConfig,
+	stopCh <-chan struct{}, run func(stopCh <-chan struct{})) error {
+
+	electionConfig, err := newLeaderElectionConfig(kubeclientset, config, stopCh, run)
+	if err != nil {
+		return err
+	}
+	elector, err := leaderelection.NewLeaderElector(electionConfig)
+	if err != nil {
+		return fmt.Errorf("invalid leader election config: %w", err)
+	}
+	if config.watchDog != nil {
+		config.watchDog.SetLeaderElection(elector)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	lock := electionConfig.Lock
+	klog.Infof("Starting leader election for lease %s as %s", lock.Describe(), lock.Identity())
+	elector.Run(ctx)
+	return nil
+}
+
+// newLeaderElectionConfig returns the config of the election for the Lease,
+// which is created in the namespace of the Pod unless configured.
+func newLeaderElectionConfig(kubeclientset kubernetes.Interface, config leaderElectionConfig,
+	stopCh <-chan struct{}, run func(stopCh <-chan struct{})) (leaderelection.LeaderElectionConfig, error) {
+
+	identity, err := leaderElectionIdentity()
+	if err != nil {
+		return leaderelection.LeaderElectionConfig{}, err
+	}
+
+	namespace := config.namespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		return leaderelection.LeaderElectionConfig{}, fmt.Errorf("please set leader election namespace")
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.leaseName,
+			Namespace: namespace,
+		},
+		Client: kubeclientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	return leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   config.leaseDuration,
+		RenewDeadline:   config.renewDeadline,
+		RetryPeriod:     config.retryPeriod,
+		Name:            config.leaseName,
+		WatchDog:        config.watchDog,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Info("Started leading")
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-stopCh:
+					klog.Info("Stopped leading")
+				default:
+					klog.Fatalf("Leader election lost")
+				}
+			},
+			OnNewLeader: func(current string) {
+				if current == identity {
+					return
+				}
+				klog.Infof("New leader elected: %s", current)
+			},
+		},
+	}, nil
+}
+
+func leaderElectionIdentity() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname for leader election identity: %w", err)
+	}
+	return hostname, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func newTestLeaderElectionConfig() leaderElectionConfig {
+	return leaderElectionConfig{
+		enabled:       true,
+		leaseName:     "kube-job-notifier",
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 10 * time.Second,
+		retryPeriod:   2 * time.Second,
+	}
+}
+
+func TestNewLeaderElectionConfig(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	run := func(<-chan struct{}) {}
+	t.Setenv("POD_NAME", "kube-job-notifier-0")
+
+	t.Run("namespace of the pod", func(t *testing.T) {
+		t.Setenv("POD_NAMESPACE", "monitoring")
+
+		cfg, err := newLeaderElectionConfig(client, newTestLeaderElectionConfig(), nil, run)
+		assert.NoError(t, err)
+		lock := cfg.Lock.(*resourcelock.LeaseLock)
+		assert.Equal(t, "monitoring", lock.LeaseMeta.Namespace)
+		assert.Equal(t, "kube-job-notifier", lock.LeaseMeta.Name)
+		assert.Equal(t, "kube-job-notifier-0", lock.Identity())
+		assert.Equal(t, "kube-job-notifier", cfg.Name)
+		assert.Equal(t, 15*time.Second, cfg.LeaseDuration)
+		assert.Equal(t, 10*time.Second, cfg.RenewDeadline)
+		assert.Equal(t, 2*time.Second, cfg.RetryPeriod)
+		assert.True(t, cfg.ReleaseOnCancel)
+
+		_, err = leaderelection.NewLeaderElector(cfg)
+		assert.NoError(t, err)
+	})
+
+	t.Run("configured namespace", func(t *testing.T) {
+		t.Setenv("POD_NAMESPACE", "monitoring")
+		config := newTestLeaderElectionConfig()
+		config.namespace = "kube-system"
+
+		cfg, err := newLeaderElectionConfig(client, config, nil, run)
+		assert.NoError(t, err)
+		assert.Equal(t, "kube-system", cfg.Lock.(*resourcelock.LeaseLock).LeaseMeta.Namespace)
+	})
+
+	t.Run("without namespace", func(t *testing.T) {
+		t.Setenv("POD_NAMESPACE", "")
+
+		_, err := newLeaderElectionConfig(client, newTestLeaderElectionConfig(), nil, run)
+		assert.EqualError(t, err, "please set leader election namespace")
+	})
+}
+
+func TestRunWithLeaderElectionInvalid(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	t.Setenv("POD_NAME", "kube-job-notifier-0")
+	t.Setenv("POD_NAMESPACE", "monitoring")
+
+	tests := []struct {
+		name          string
+		renewDeadline time.Duration
+		expected      string
+	}{
+		{"renew deadline equal to lease duration", 15 * time.Second, "leaseDuration must be greater than renewDeadline"},
+		{"renew deadline longer than lease duration", 20 * time.Second, "leaseDuration must be greater than renewDeadline"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestLeaderElectionConfig()
+			config.renewDeadline = test.renewDeadline
+
+			err := runWithLeaderElection(client, config, make(chan struct{}), func(<-chan struct{}) {
+				t.Error("run must not be called")
+			})
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+}
@@ -2,22 +2,46 @@ package main
 
 import (
 	"flag"
-	"os"
 	"os/user"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/yutachaos/kube-job-notifier/pkg/signals"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/klog"
 )
 
 var (
 	masterURL  string
 	kubeconfig string
+	workers    int
+	config     controllerConfig
 	kubeClient kubernetes.Interface
+
+	leaderElection leaderElectionConfig
+
+	// clusters are the clusters whose Jobs are notified. kubeClient is only
+	// used for leader election when several clusters are watched.
+	clusters      []cluster
+	clusterName   string
+	contexts      string
+	kubeconfigDir string
+
+	configPath           string
+	configReloadInterval time.Duration
+	reloader             configReloader
+
+	metricsAddress     string
+	healthProbeAddress string
 )
 
 func main() {
@@ -26,43 +50,168 @@ func main() {
 
 	stopCh := signals.SetupSignalHandler()
 
-	if _, err := rest.InClusterConfig(); err != nil {
-		cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
-		if err != nil {
-			klog.Fatalf("Error building kubeclient: %s", err.Error())
-		}
-		kubeClient, err = kubernetes.NewForConfig(cfg)
+	fileCfg, err := loadConfigFile(configPath)
+	if err != nil {
+		klog.Fatalf("Error loading config: %s", err.Error())
+	}
+	reloader.config = fileCfg
+	if configPath != "" {
+		go watchConfigFile(configPath, configReloadInterval, reloader.reload, stopCh)
+	}
+	if metricsAddress != "" {
+		go serveMetrics(metricsAddress, stopCh)
+	}
+	if leaderElection.enabled {
+		leaderElection.watchDog = leaderelection.NewLeaderHealthzAdaptor(leaderElectionTolerance)
+		healthChecks.leaderElection = leaderElection.watchDog
+	}
+	if healthProbeAddress != "" {
+		go serveHealthProbes(healthProbeAddress, stopCh)
+	}
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		cfg, err = clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 		if err != nil {
 			klog.Fatalf("Error building kubeclient: %s", err.Error())
 		}
-	} else {
-		cfg, err := rest.InClusterConfig()
-		if err != nil {
-			klog.Fatalf("Error building in cluster config: %s", err.Error())
-		}
-		kubeClient, err = kubernetes.NewForConfig(cfg)
+	}
+	kubeClient, err = kubernetes.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building kubeclient: %s", err.Error())
+	}
+
+	clusters, err = loadClusters(kubeconfig, splitList(contexts), kubeconfigDir)
+	if err != nil {
+		klog.Fatalf("Error loading clusters: %s", err.Error())
+	}
+	if len(clusters) == 0 {
+		clusters = []cluster{{name: clusterName, config: cfg}}
+	}
+
+	if !leaderElection.enabled {
+		run(stopCh)
+		return
+	}
+	lead := func(stopCh <-chan struct{}) {
+		healthChecks.setLeading(true)
+		run(stopCh)
+	}
+	if err := runWithLeaderElection(kubeClient, leaderElection, stopCh, lead); err != nil {
+		klog.Fatalf("Error running leader election: %s", err.Error())
+	}
+}
+
+// run starts the informers and controllers of every cluster, and blocks until
+// stopCh is closed.
+func run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, cl := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runCluster(cl, stopCh)
+		}()
+	}
+	wg.Wait()
+}
+
+// runCluster starts the informers and a controller for every watched
+// namespace of the cluster, and blocks until stopCh is closed.
+func runCluster(cl cluster, stopCh <-chan struct{}) {
+	klog.Infof("Watching cluster %q at %s", cl.name, cl.config.Host)
+	kubeClient, err := kubernetes.NewForConfig(cl.config)
+	if err != nil {
+		klog.Fatalf("Error building kubeclient for cluster %s: %s", cl.name, err.Error())
+	}
+	var dynamicClient dynamic.Interface
+	if config.notificationPolicies {
+		dynamicClient, err = dynamic.NewForConfig(cl.config)
 		if err != nil {
-			klog.Fatalf("Error building in cluster kubeclient: %s", err.Error())
+			klog.Fatalf("Error building dynamic client for cluster %s: %s", cl.name, err.Error())
 		}
 	}
+	config := config
+	config.clusterName = cl.name
 
-	// Specified namespace
-	namespace := os.Getenv("NAMESPACE")
-	var kubeInformerFactory kubeinformers.SharedInformerFactory
-	// Sync event only
-	if namespace == "" {
-		kubeInformerFactory = kubeinformers.NewSharedInformerFactory(kubeClient, 0)
-	} else {
-		kubeInformerFactory = kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace(namespace))
+	// Filters were validated when the config file was loaded.
+	filters := reloader.current().Filters
+	excluded := filters.ExcludeNamespaces
+	namespaces := getWatchedNamespaces(filters.Namespaces, excluded)
+	if filters.NamespaceSelector != "" {
+		config.namespaceSelector, _ = labels.Parse(filters.NamespaceSelector)
+	}
+	if filters.JobLabelSelector != "" {
+		config.jobLabelSelector, _ = labels.Parse(filters.JobLabelSelector)
 	}
 
-	controller := NewController(kubeClient, kubeInformerFactory.Batch().V1().Jobs())
+	// Namespaces are cached for their labels and annotations. When all
+	// namespaces are watched, one informer is shared by the controllers;
+	// otherwise each controller only watches its own Namespace.
+	var namespaceInformerFactory kubeinformers.SharedInformerFactory
+	if namespaces[0] == metav1.NamespaceAll {
+		namespaceInformerFactory = newNamespaceInformerFactory(kubeClient, metav1.NamespaceAll)
+	}
+
+	jobLabelSelector := filters.JobLabelSelector
+
+	// One set of informers and controller per namespace, so that RBAC can be
+	// granted with a Role in each watched namespace.
+	var wg sync.WaitGroup
+	for _, namespace := range namespaces {
+		namespaceInformerFactory := namespaceInformerFactory
+		if namespaceInformerFactory == nil {
+			namespaceInformerFactory = newNamespaceInformerFactory(kubeClient, namespace)
+		}
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+			newInformerOptions(namespace, excluded, "")...)
+		// Jobs are listed from their own factory, so that the label selector
+		// does not apply to CronJobs.
+		jobInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+			newInformerOptions(namespace, excluded, jobLabelSelector)...)
+		// Only Pods created by Jobs are cached
+		podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+			newInformerOptions(namespace, excluded, searchLabel)...)
+
+		config := config
+		config.namespace = namespace
+		controller := NewController(kubeClient,
+			jobInformerFactory.Batch().V1().Jobs(),
+			kubeInformerFactory.Batch().V1().CronJobs(),
+			podInformerFactory.Core().V1().Pods(),
+			namespaceInformerFactory.Core().V1().Namespaces(),
+			config)
+		if err := reloader.register(controller); err != nil {
+			klog.Fatalf("Error applying config: %s", err.Error())
+		}
+		controllerMetrics.register(controller)
+		healthChecks.register(controller)
+		if dynamicClient != nil {
+			policyInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0,
+				namespace, newTweakListOptions(namespace, excluded, ""))
+			// Only the Secrets opted in to policies are cached.
+			secretInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+				newInformerOptions(namespace, excluded, policySecretLabelName+"=true")...)
+			controller.enablePolicies(dynamicClient, policyInformerFactory.ForResource(policyGVR),
+				secretInformerFactory.Core().V1().Secrets())
+			policyInformerFactory.Start(stopCh)
+			secretInformerFactory.Start(stopCh)
+		}
 
-	kubeInformerFactory.Start(stopCh)
+		namespaceInformerFactory.Start(stopCh)
+		kubeInformerFactory.Start(stopCh)
+		jobInformerFactory.Start(stopCh)
+		podInformerFactory.Start(stopCh)
 
-	if err := controller.Run(stopCh); err != nil {
-		klog.Fatalf("Error running controller: %s", err.Error())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := controller.Run(workers, stopCh); err != nil {
+				klog.Fatalf("Error running controller: %s", err.Error())
+			}
+		}()
 	}
+	wg.Wait()
 }
 
 func init() {
@@ -73,4 +222,27 @@ func init() {
 	// set kubeconfig flag
 	flag.StringVar(&kubeconfig, "kubeconfig", defaultPath, "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&configPath, "config", "", "Path to a YAML config file of sinks, filters and templates. Environment variables override it.")
+	flag.DurationVar(&configReloadInterval, "config-reload-interval", 10*time.Second, "How often the config file is checked for changes.")
+	flag.StringVar(&contexts, "contexts", "", "Comma-separated list of kubeconfig contexts of the clusters to watch. Each cluster is named after its context.")
+	flag.StringVar(&kubeconfigDir, "kubeconfig-dir", "", "Directory of kubeconfig files of the clusters to watch, e.g. a mounted Secret. Each cluster is named after its file without extension.")
+	flag.StringVar(&clusterName, "cluster-name", "", "Name of the cluster added to messages when neither -contexts nor -kubeconfig-dir is set.")
+	flag.StringVar(&metricsAddress, "metrics-bind-address", ":8080", "Address the Prometheus metrics are served on at /metrics. Empty disables the metrics server.")
+	flag.StringVar(&healthProbeAddress, "health-probe-bind-address", ":8081", "Address the /healthz and /readyz probes are served on. Empty disables the probes.")
+	flag.DurationVar(&healthChecks.deliveryThreshold, "readiness-delivery-threshold", time.Hour, "Report the notifier as not ready when deliveries to a sink have been failing for longer than this duration without a success. 0 disables the check.")
+	flag.IntVar(&workers, "workers", 2, "Number of workers that process Job events concurrently.")
+	flag.DurationVar(&config.catchUpWindow, "catch-up-window", time.Hour, "Jobs that started or finished within this window before startup and were not notified yet are notified on startup.")
+	flag.DurationVar(&config.missedScheduleGracePeriod, "missed-schedule-grace-period", 0, "Notify a missed schedule when a CronJob run is overdue by more than this duration. 0 disables the check.")
+	flag.DurationVar(&config.missedScheduleCheckInterval, "missed-schedule-check-interval", time.Minute, "How often CronJob schedules are checked for missed runs.")
+	flag.DurationVar(&config.defaultExpectedDuration, "default-expected-duration", 0, "Notify Jobs running longer than this duration unless overridden by the kube-job-notifier/expected-duration annotation. 0 disables the notification.")
+	flag.DurationVar(&config.stuckPodThreshold, "stuck-pod-threshold", 0, "Notify Jobs whose pod stays Pending for longer than this duration because it cannot be scheduled or its image cannot be pulled, e.g. 10m. 0 disables the notification.")
+	flag.IntVar(&config.failureEvents, "failure-events", 5, "Maximum number of Kubernetes Events of a failed Job and its pods, one per reason, included in failure notifications. 0 disables it.")
+	flag.BoolVar(&config.notificationPolicies, "notification-policies", false, "Route the notifications of Jobs selected by NotificationPolicy resources to their destinations. Requires the NotificationPolicy CRD.")
+	flag.BoolVar(&config.optIn, "opt-in", false, "Only notify Jobs, CronJobs and Namespaces annotated with kube-job-notifier/enabled: \"true\".")
+	flag.BoolVar(&leaderElection.enabled, "leader-elect", false, "Enable leader election so that only one of several replicas sends notifications.")
+	flag.StringVar(&leaderElection.leaseName, "leader-election-lease-name", "kube-job-notifier", "Name of the Lease used for leader election.")
+	flag.StringVar(&leaderElection.namespace, "leader-election-namespace", "", "Namespace of the Lease used for leader election. Defaults to the POD_NAMESPACE environment variable.")
+	flag.DurationVar(&leaderElection.leaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration that standby replicas wait before taking over the Lease.")
+	flag.DurationVar(&leaderElection.renewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration that the leader retries refreshing the Lease before giving it up.")
+	flag.DurationVar(&leaderElection.retryPeriod, "leader-election-retry-period", 2*time.Second, "Duration between leader election actions.")
 }
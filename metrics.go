@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+const metricsNamespace = "kube_job_notifier"
+
+// Results of notification deliveries and finished Jobs.
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+// serverShutdownTimeout bounds how long in-flight requests may take once the
+// process is stopping.
+const serverShutdownTimeout = 5 * time.Second
+
+// registry holds the metrics served by the metrics server. It is not the
+// global Prometheus registry, so that only the metrics below are exposed.
+var registry = prometheus.NewRegistry()
+
+var (
+	jobsStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_started_total",
+		Help:      "Number of Jobs that started.",
+	}, []string{"cluster", "namespace", "cronjob"})
+	jobsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_succeeded_total",
+		Help:      "Number of Jobs that succeeded.",
+	}, []string{"cluster", "namespace", "cronjob"})
+	jobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_failed_total",
+		Help:      "Number of Jobs that failed.",
+	}, []string{"cluster", "namespace", "cronjob"})
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time from the start to the end of finished Jobs.",
+		// 1s to about 3 days.
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"cluster", "namespace", "cronjob", "result"})
+
+	notificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_total",
+		Help:      "Number of notification deliveries by sink and result.",
+	}, []string{"sink", "result"})
+	notificationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "notification_duration_seconds",
+		Help:      "Latency of notification deliveries by sink and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink", "result"})
+
+	// controllerMetrics reports the state cached by the informers of the
+	// registered controllers when metrics are scraped.
+	controllerMetrics = &controllerCollector{}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		jobsStarted,
+		jobsSucceeded,
+		jobsFailed,
+		jobDuration,
+		notificationsTotal,
+		notificationDuration,
+		controllerMetrics,
+		workqueueDepth,
+		workqueueAdds,
+		workqueueLatency,
+		workqueueWorkDuration,
+		workqueueUnfinishedWork,
+		workqueueLongestRunningProcessor,
+		workqueueRetries,
+	)
+	// Queues only report to the provider set before they are created.
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+// serveMetrics serves the metrics at /metrics on addr until stopCh is
+// closed. Standby replicas serve them too, so that they can be scraped while
+// waiting for leadership.
+func serveMetrics(addr string, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	serveHTTP("metrics", addr, mux, stopCh)
+}
+
+// serveHTTP serves the handler on addr until stopCh is closed.
+func serveHTTP(name, addr string, handler http.Handler, stopCh <-chan struct{}) {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			klog.Errorf("Error shutting down %s server: %v", name, err)
+		}
+	}()
+
+	klog.Infof("Serving %s on %s", name, addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Fatalf("Error serving %s: %s", name, err.Error())
+	}
+}
+
+// recordJobMetrics counts the start and the end of a Job when an update of
+// the Job shows them. old is nil for Jobs added to the cache, which are only
+// counted if they were created after the controller, so that the Jobs listed
+// on startup are not counted again.
+func (c *Controller) recordJobMetrics(old, job *batchv1.Job) {
+	if old == nil {
+		if job.CreationTimestamp.Time.Before(c.startedAt) {
+			return
+		}
+		old = &batchv1.Job{}
+	}
+	cronJobName := getCronJobNameFromOwnerReferences(job)
+	if old.Status.StartTime == nil && job.Status.StartTime != nil {
+		jobsStarted.WithLabelValues(c.config.clusterName, job.Namespace, cronJobName).Inc()
+	}
+	finished := getFinishedCondition(job)
+	if finished == nil || getFinishedCondition(old) != nil {
+		return
+	}
+	result := resultFailure
+	if isSucceededCondition(finished.Type) {
+		result = resultSuccess
+		jobsSucceeded.WithLabelValues(c.config.clusterName, job.Namespace, cronJobName).Inc()
+	} else {
+		jobsFailed.WithLabelValues(c.config.clusterName, job.Namespace, cronJobName).Inc()
+	}
+	if job.Status.StartTime != nil {
+		jobDuration.WithLabelValues(c.config.clusterName, job.Namespace, cronJobName, result).
+			Observe(jobFinishedTime(finished).Sub(job.Status.StartTime.Time).Seconds())
+	}
+}
+
+// recordDeliveryMetrics counts a delivery to the sink. duration is zero for
+// targets that could not be resolved, which are counted but not observed.
+func recordDeliveryMetrics(sink string, err error, duration time.Duration) {
+	result := resultSuccess
+	if err != nil {
+		result = resultFailure
+	}
+	notificationsTotal.WithLabelValues(sink, result).Inc()
+	if duration > 0 {
+		notificationDuration.WithLabelValues(sink, result).Observe(duration.Seconds())
+	}
+}
+
+var (
+	runningJobsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "running_jobs"),
+		"Number of Jobs that started and did not finish yet.",
+		[]string{"cluster", "namespace", "cronjob"}, nil)
+	lastSuccessDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cronjob_last_success_timestamp_seconds"),
+		"Time the last successful Job of the CronJob finished, from the CronJob status.",
+		[]string{"cluster", "namespace", "cronjob"}, nil)
+	cachedObjectsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "informer_cached_objects"),
+		"Number of objects in the informer caches by resource.",
+		[]string{"cluster", "resource"}, nil)
+)
+
+// controllerCollector computes the gauges from the informer caches of the
+// controllers, so that they are right from the first scrape after a restart.
+// One collector reports for every controller, as the registry rejects
+// collectors reporting the same metrics.
+type controllerCollector struct {
+	mu          sync.Mutex
+	controllers []*Controller
+}
+
+// register adds the controller to the metrics.
+func (cc *controllerCollector) register(c *Controller) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.controllers = append(cc.controllers, c)
+}
+
+// Describe implements prometheus.Collector.
+func (cc *controllerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runningJobsDesc
+	ch <- lastSuccessDesc
+	ch <- cachedObjectsDesc
+}
+
+// gaugeKey identifies a gauge by its label values.
+type gaugeKey struct {
+	cluster, namespace, name string
+}
+
+// Collect implements prometheus.Collector. The controllers of a cluster
+// watch different namespaces, so their values are added up per cluster.
+func (cc *controllerCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	running := make(map[gaugeKey]int)
+	lastSuccess := make(map[gaugeKey]time.Time)
+	cached := make(map[gaugeKey]int)
+	for _, c := range cc.controllers {
+		cluster := c.config.clusterName
+		jobs, err := c.jobsLister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("Error listing Jobs for metrics: %v", err)
+		}
+		for _, job := range jobs {
+			if job.Status.StartTime != nil && getFinishedCondition(job) == nil {
+				running[gaugeKey{cluster, job.Namespace, getCronJobNameFromOwnerReferences(job)}]++
+			}
+		}
+		cronJobs, err := c.cronJobLister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("Error listing CronJobs for metrics: %v", err)
+		}
+		for _, cronJob := range cronJobs {
+			if cronJob.Status.LastSuccessfulTime != nil {
+				lastSuccess[gaugeKey{cluster, cronJob.Namespace, cronJob.Name}] = cronJob.Status.LastSuccessfulTime.Time
+			}
+		}
+		cached[gaugeKey{cluster: cluster, name: "jobs"}] += len(jobs)
+		cached[gaugeKey{cluster: cluster, name: "cronjobs"}] += len(cronJobs)
+		cached[gaugeKey{cluster: cluster, name: "pods"}] += len(c.podsIndexer.ListKeys())
+	}
+
+	for k, n := range running {
+		ch <- prometheus.MustNewConstMetric(runningJobsDesc, prometheus.GaugeValue, float64(n), k.cluster, k.namespace, k.name)
+	}
+	for k, t := range lastSuccess {
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, float64(t.Unix()), k.cluster, k.namespace, k.name)
+	}
+	for k, n := range cached {
+		ch <- prometheus.MustNewConstMetric(cachedObjectsDesc, prometheus.GaugeValue, float64(n), k.cluster, k.name)
+	}
+}
+
+// workqueueMetricsProvider reports the metrics of the client-go work queues
+// under the names used by Kubernetes controllers, labeled by queue name.
+type workqueueMetricsProvider struct{}
+
+var (
+	workqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Current depth of the work queue.",
+	}, []string{"name"})
+	workqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Number of adds handled by the work queue.",
+	}, []string{"name"})
+	workqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long an item stays in the work queue before being requested.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 12),
+	}, []string{"name"})
+	workqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long processing an item from the work queue takes.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 12),
+	}, []string{"name"})
+	workqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How many seconds of work is in progress and has not been observed by work_duration yet.",
+	}, []string{"name"})
+	workqueueLongestRunningProcessor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How many seconds the longest running processor of the work queue has been running.",
+	}, []string{"name"})
+	workqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Number of retries handled by the work queue.",
+	}, []string{"name"})
+)
+
+// NewDepthMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+// NewAddsMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+// NewLatencyMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+// NewWorkDurationMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+// NewUnfinishedWorkSecondsMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueUnfinishedWork.WithLabelValues(name)
+}
+
+// NewLongestRunningProcessorSecondsMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueLongestRunningProcessor.WithLabelValues(name)
+}
+
+// NewRetriesMetric implements workqueue.MetricsProvider.
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}
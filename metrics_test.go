@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newMetricsTestJob(name string, created time.Time) *batchv1.Job {
+	return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "metrics",
+		UID:               types.UID("uid-metrics-" + name),
+		CreationTimestamp: metav1.NewTime(created),
+		OwnerReferences:   []metav1.OwnerReference{{Kind: "CronJob", Name: "nightly"}},
+	}}
+}
+
+func TestRecordJobMetrics(t *testing.T) {
+	c, _ := newTestController(t, nil)
+	c.config.clusterName = "record-job-metrics"
+	labels := []string{c.config.clusterName, "metrics", "nightly"}
+
+	old := newMetricsTestJob("old", c.startedAt.Add(-time.Hour))
+	old.Status.StartTime = &metav1.Time{Time: c.startedAt.Add(-time.Hour)}
+	c.recordJobMetrics(nil, old)
+	assert.Equal(t, 0.0, testutil.ToFloat64(jobsStarted.WithLabelValues(labels...)), "Jobs listed on startup are not counted")
+
+	created := newMetricsTestJob("new", c.startedAt.Add(time.Second))
+	c.recordJobMetrics(nil, created)
+	started := created.DeepCopy()
+	started.Status.StartTime = &metav1.Time{Time: created.CreationTimestamp.Add(time.Second)}
+	c.recordJobMetrics(created, started)
+	assert.Equal(t, 1.0, testutil.ToFloat64(jobsStarted.WithLabelValues(labels...)))
+
+	succeeded := started.DeepCopy()
+	succeeded.Status.Conditions = []batchv1.JobCondition{{
+		Type:               batchv1.JobComplete,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.Time{Time: started.Status.StartTime.Add(90 * time.Second)},
+	}}
+	c.recordJobMetrics(started, succeeded)
+	c.recordJobMetrics(succeeded, succeeded.DeepCopy())
+	assert.Equal(t, 1.0, testutil.ToFloat64(jobsStarted.WithLabelValues(labels...)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(jobsSucceeded.WithLabelValues(labels...)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(jobsFailed.WithLabelValues(labels...)))
+
+	var m dto.Metric
+	histogram := jobDuration.WithLabelValues(append(labels, resultSuccess)...).(prometheus.Histogram)
+	assert.NoError(t, histogram.Write(&m))
+	assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+	assert.Equal(t, 90.0, m.GetHistogram().GetSampleSum())
+}
+
+func TestRecordDeliveryMetrics(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default", UID: "uid-delivery-metrics"}}
+	c, _ := newTestController(t, job)
+	c.notifications = map[string]notification.Notification{
+		"metrics-ok":    &fakeNotification{},
+		"metrics-error": &fakeNotification{err: errors.New("unavailable")},
+	}
+
+	err := c.notify(job, string(job.UID), eventStart, notification.MessageTemplateParam{JobName: job.Name},
+		notification.Notification.NotifyStart, monitoring.JobInfo{}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(notificationsTotal.WithLabelValues("metrics-ok", resultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(notificationsTotal.WithLabelValues("metrics-error", resultFailure)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(notificationsTotal.WithLabelValues("metrics-ok", resultFailure)))
+}
+
+func TestControllerCollector(t *testing.T) {
+	running := newMetricsTestJob("running", time.Now())
+	running.Status.StartTime = &metav1.Time{Time: time.Now()}
+	pending := newMetricsTestJob("pending", time.Now())
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "metrics"},
+		Status:     batchv1.CronJobStatus{LastSuccessfulTime: &metav1.Time{Time: time.Unix(1700000000, 0)}},
+	}
+	c, _ := newTestController(t, running, pending, cronJob)
+	c.config.clusterName = "prod"
+
+	collector := &controllerCollector{}
+	collector.register(c)
+	expected := `
+# HELP kube_job_notifier_cronjob_last_success_timestamp_seconds Time the last successful Job of the CronJob finished, from the CronJob status.
+# TYPE kube_job_notifier_cronjob_last_success_timestamp_seconds gauge
+kube_job_notifier_cronjob_last_success_timestamp_seconds{cluster="prod",cronjob="nightly",namespace="metrics"} 1.7e+09
+# HELP kube_job_notifier_informer_cached_objects Number of objects in the informer caches by resource.
+# TYPE kube_job_notifier_informer_cached_objects gauge
+kube_job_notifier_informer_cached_objects{cluster="prod",resource="cronjobs"} 1
+kube_job_notifier_informer_cached_objects{cluster="prod",resource="jobs"} 2
+kube_job_notifier_informer_cached_objects{cluster="prod",resource="pods"} 0
+# HELP kube_job_notifier_running_jobs Number of Jobs that started and did not finish yet.
+# TYPE kube_job_notifier_running_jobs gauge
+kube_job_notifier_running_jobs{cluster="prod",cronjob="nightly",namespace="metrics"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	newController := func(namespace string) *Controller {
+		informerFactory := kubeinformers.NewSharedInformerFactory(fakeClient, 0)
+		return NewController(fakeClient,
+			informerFactory.Batch().V1().Jobs(),
+			informerFactory.Batch().V1().CronJobs(),
+			informerFactory.Core().V1().Pods(),
+			nil,
+			controllerConfig{clusterName: "metrics", namespace: namespace})
+	}
+	billing := newController("billing")
+	search := newController("search")
+	defer billing.workqueue.ShutDown()
+	defer search.workqueue.ShutDown()
+
+	billing.workqueue.Add("billing/nightly-1")
+	billing.workqueue.Add("billing/nightly-2")
+	search.workqueue.Add("search/reindex-1")
+	assert.Equal(t, float64(2), testutil.ToFloat64(workqueueDepth.WithLabelValues("Jobs/metrics/billing")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(workqueueDepth.WithLabelValues("Jobs/metrics/search")))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/thoas/go-funk"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// getWatchedNamespaces returns the namespaces to run informers for, given the
+// comma-separated NAMESPACE and EXCLUDE_NAMESPACES lists. Excluded namespaces
+// are dropped from the list; an empty list watches all namespaces.
+func getWatchedNamespaces(namespaces, excluded []string) []string {
+	if len(namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	var watched []string
+	for _, namespace := range namespaces {
+		if funk.ContainsString(excluded, namespace) {
+			klog.Warningf("Namespace %s is both watched and excluded, excluding it", namespace)
+			continue
+		}
+		watched = append(watched, namespace)
+	}
+	return watched
+}
+
+// newInformerOptions returns the informer options that restrict a factory to
+// the namespace and to objects matching the label selector.
+func newInformerOptions(namespace string, excluded []string, labelSelector string) []kubeinformers.SharedInformerOption {
+	return []kubeinformers.SharedInformerOption{
+		kubeinformers.WithNamespace(namespace),
+		kubeinformers.WithTweakListOptions(newTweakListOptions(namespace, excluded, labelSelector)),
+	}
+}
+
+// newNamespaceInformerFactory returns the factory of the Namespace informer of
+// the controller of the namespace. When a list of namespaces is watched, it
+// only lists the watched Namespace by name, so that access can be granted on
+// the listed Namespaces only.
+func newNamespaceInformerFactory(client kubernetes.Interface, namespace string) kubeinformers.SharedInformerFactory {
+	if namespace == metav1.NamespaceAll {
+		return kubeinformers.NewSharedInformerFactory(client, 0)
+	}
+	return kubeinformers.NewSharedInformerFactoryWithOptions(client, 0,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", namespace).String()
+		}))
+}
+
+// newTweakListOptions returns the list options tweak that restricts an
+// informer to objects matching the label selector. Excluded namespaces are
+// filtered by the API server when all namespaces are watched. A factory only
+// keeps its last tweak, so both selectors are set by one.
+func newTweakListOptions(namespace string, excluded []string, labelSelector string) func(*metav1.ListOptions) {
+	var fieldSelector string
+	if namespace == metav1.NamespaceAll && len(excluded) > 0 {
+		selectors := make([]fields.Selector, 0, len(excluded))
+		for _, namespace := range excluded {
+			selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+		}
+		fieldSelector = fields.AndSelectors(selectors...).String()
+	}
+	return func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+		options.FieldSelector = fieldSelector
+	}
+}
+
+// isNamespaceWatched reports whether the labels of the namespace match the
+// namespace selector. Namespaces are always watched without a selector.
+func (c *Controller) isNamespaceWatched(namespace string) bool {
+	if c.config.namespaceSelector == nil || c.config.namespaceSelector.Empty() {
+		return true
+	}
+	ns, err := c.namespaceLister.Get(namespace)
+	if err != nil {
+		klog.Errorf("Get namespace %s failed: %v", namespace, err)
+		return false
+	}
+	return c.config.namespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// getNamespaceAnnotations returns the annotations of the namespace, or nil if
+// namespaces are not watched or it is not found.
+func (c *Controller) getNamespaceAnnotations(namespace string) map[string]string {
+	if c.namespaceLister == nil {
+		return nil
+	}
+	ns, err := c.namespaceLister.Get(namespace)
+	if err != nil {
+		klog.V(4).Infof("Get namespace %s failed: %v", namespace, err)
+		return nil
+	}
+	return ns.Annotations
+}
+
+// splitList splits a comma-separated list, ignoring blank entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
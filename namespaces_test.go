@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetWatchedNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		excluded   []string
+		expected   []string
+	}{
+		{
+			name:     "all namespaces",
+			excluded: []string{"kube-system"},
+			expected: []string{metav1.NamespaceAll},
+		},
+		{
+			name:       "namespace list",
+			namespaces: []string{"team-a", "team-b"},
+			expected:   []string{"team-a", "team-b"},
+		},
+		{
+			name:       "excluded namespace is dropped from list",
+			namespaces: []string{"team-a", "kube-system"},
+			excluded:   []string{"kube-system"},
+			expected:   []string{"team-a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, getWatchedNamespaces(test.namespaces, test.excluded))
+		})
+	}
+}
+
+func TestNewInformerOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		excluded      []string
+		labelSelector string
+		expectedNs    string
+		expectedField string
+	}{
+		{
+			name:          "all namespaces except excluded",
+			namespace:     metav1.NamespaceAll,
+			excluded:      []string{"kube-system", "kube-public"},
+			labelSelector: searchLabel,
+			expectedField: "metadata.namespace!=kube-public,metadata.namespace!=kube-system",
+		},
+		{
+			name:          "single namespace",
+			namespace:     "team-a",
+			excluded:      []string{"kube-system"},
+			labelSelector: "team=billing",
+			expectedNs:    "team-a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleClientset()
+			factory := kubeinformers.NewSharedInformerFactoryWithOptions(fakeClient, 0,
+				newInformerOptions(test.namespace, test.excluded, test.labelSelector)...)
+			factory.Core().V1().Pods().Informer()
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			factory.Start(stopCh)
+			factory.WaitForCacheSync(stopCh)
+
+			list := fakeClient.Actions()[0].(k8stesting.ListAction)
+			assert.Equal(t, test.expectedNs, list.GetNamespace())
+			assert.Equal(t, test.labelSelector, list.GetListRestrictions().Labels.String())
+			assert.Equal(t, test.expectedField, list.GetListRestrictions().Fields.String())
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	assert.Nil(t, splitList(""))
+	assert.Equal(t, []string{"team-a", "team-b"}, splitList(" team-a, ,team-b,"))
+}
+
+func TestNewNamespaceInformerFactory(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		expectedField string
+	}{
+		{name: "all namespaces", namespace: metav1.NamespaceAll},
+		{name: "listed namespace", namespace: "team-a", expectedField: "metadata.name=team-a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "team-a",
+				Annotations: map[string]string{notification.MsTeamsV2WebhookURLAnnotationName: "https://example.com/team-a"},
+			}})
+			factory := newNamespaceInformerFactory(fakeClient, test.namespace)
+			namespaceInformer := factory.Core().V1().Namespaces()
+			c := NewController(fakeClient,
+				factory.Batch().V1().Jobs(), factory.Batch().V1().CronJobs(), factory.Core().V1().Pods(),
+				namespaceInformer, controllerConfig{})
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			factory.Start(stopCh)
+			factory.WaitForCacheSync(stopCh)
+
+			var listed bool
+			for _, action := range fakeClient.Actions() {
+				if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "namespaces" {
+					assert.Equal(t, test.expectedField, list.GetListRestrictions().Fields.String())
+					listed = true
+				}
+			}
+			assert.True(t, listed)
+			assert.Equal(t, "https://example.com/team-a",
+				c.getNamespaceAnnotations("team-a")[notification.MsTeamsV2WebhookURLAnnotationName])
+		})
+	}
+}
+
+func TestIsNamespaceWatched(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	namespaceInformer := kubeinformers.NewSharedInformerFactory(fakeClient, 0).Core().V1().Namespaces()
+	for _, ns := range []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"notify": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	} {
+		assert.NoError(t, namespaceInformer.Informer().GetIndexer().Add(ns))
+	}
+	c := &Controller{namespaceLister: namespaceInformer.Lister()}
+
+	assert.True(t, c.isNamespaceWatched("team-b"), "no selector")
+
+	c.config.namespaceSelector = labels.SelectorFromSet(labels.Set{"notify": "true"})
+	assert.True(t, c.isNamespaceWatched("team-a"))
+	assert.False(t, c.isNamespaceWatched("team-b"))
+	assert.False(t, c.isNamespaceWatched("unknown"))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventStart       = "start"
+	eventStuck       = "stuck"
+	eventLongRunning = "long-running"
+	eventSuccess     = "success"
+	eventFailed      = "failed"
+
+	// eventRetry is suffixed with the number of failed attempts, e.g.
+	// "retry-2", so that every attempt is notified once.
+	eventRetry = "retry"
+)
+
+// notifiedEvents is the order in which events are recorded on the Job.
+var notifiedEvents = []string{eventStart, eventStuck, eventLongRunning, eventSuccess, eventFailed}
+
+// notifiedJobs keeps track of the notifications already delivered for each
+// Job UID, both per event and per sink, so that reconciling the same Job
+// again neither drops nor duplicates notifications. Completed events are
+// also persisted on the Job, see Controller.persistNotifiedEvents.
+type notifiedJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*jobNotifications
+}
+
+type jobNotifications struct {
+	done   map[string]bool
+	sent   map[string]bool
+	status deliveryStatus
+	// unpersisted is set by deliveries that were not yet written to the
+	// Job, so that a failed write is retried.
+	unpersisted bool
+}
+
+// deliveryStatus summarizes the deliveries of all events of a Job. It is
+// persisted on the Job, see Controller.persistNotifiedEvents.
+type deliveryStatus struct {
+	notifiedAt time.Time
+	// sinks are the sinks delivered to, sorted by name.
+	sinks []string
+	// slackPermalink links to the latest Slack message.
+	slackPermalink string
+}
+
+func newNotifiedJobs() *notifiedJobs {
+	return &notifiedJobs{jobs: make(map[string]*jobNotifications)}
+}
+
+func (n *notifiedJobs) get(uid string) *jobNotifications {
+	j, ok := n.jobs[uid]
+	if !ok {
+		j = &jobNotifications{done: make(map[string]bool), sent: make(map[string]bool)}
+		n.jobs[uid] = j
+	}
+	return j
+}
+
+// IsDone reports whether the event was delivered to every sink.
+func (n *notifiedJobs) IsDone(uid, event string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j, ok := n.jobs[uid]
+	return ok && j.done[event]
+}
+
+// MarkDone records that the event was delivered to every sink.
+func (n *notifiedJobs) MarkDone(uid, event string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.get(uid).done[event] = true
+}
+
+// IsSent reports whether the event was delivered to the named sink.
+func (n *notifiedJobs) IsSent(uid, event, sink string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j, ok := n.jobs[uid]
+	return ok && j.sent[event+"/"+sink]
+}
+
+// MarkSent records that the event was delivered to the named sink.
+func (n *notifiedJobs) MarkSent(uid, event, sink string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.get(uid).sent[event+"/"+sink] = true
+}
+
+// MarkDelivered records a delivery to the named sink at the given time.
+// permalink is empty for sinks other than Slack.
+func (n *notifiedJobs) MarkDelivered(uid, sink, permalink string, at time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j := n.get(uid)
+	j.unpersisted = true
+	s := &j.status
+	s.notifiedAt = at
+	s.sinks = addSink(s.sinks, sink)
+	if permalink != "" {
+		s.slackPermalink = permalink
+	}
+}
+
+// IsPersisted reports whether every delivery was written to the Job.
+func (n *notifiedJobs) IsPersisted(uid string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j, ok := n.jobs[uid]
+	return !ok || !j.unpersisted
+}
+
+// MarkPersisted records that the deliveries so far were written to the Job.
+func (n *notifiedJobs) MarkPersisted(uid string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if j, ok := n.jobs[uid]; ok {
+		j.unpersisted = false
+	}
+}
+
+// Load marks events read back from the Job as done and merges the delivery
+// status read back from the Job.
+func (n *notifiedJobs) Load(uid string, events []string, status deliveryStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j := n.get(uid)
+	for _, event := range events {
+		j.done[event] = true
+	}
+	if status.notifiedAt.After(j.status.notifiedAt) {
+		j.status.notifiedAt = status.notifiedAt
+	}
+	for _, sink := range status.sinks {
+		j.status.sinks = addSink(j.status.sinks, sink)
+	}
+	if j.status.slackPermalink == "" {
+		j.status.slackPermalink = status.slackPermalink
+	}
+}
+
+// Status returns the delivery status of the Job.
+func (n *notifiedJobs) Status(uid string) deliveryStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	j, ok := n.jobs[uid]
+	if !ok {
+		return deliveryStatus{}
+	}
+	status := j.status
+	status.sinks = append([]string(nil), j.status.sinks...)
+	return status
+}
+
+// addSink adds the sink to the sorted list unless it is already in it.
+func addSink(sinks []string, sink string) []string {
+	i := sort.SearchStrings(sinks, sink)
+	if i < len(sinks) && sinks[i] == sink {
+		return sinks
+	}
+	return append(sinks[:i], append([]string{sink}, sinks[i:]...)...)
+}
+
+// DoneEvents returns the events delivered to every sink.
+func (n *notifiedJobs) DoneEvents(uid string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var events []string
+	j, ok := n.jobs[uid]
+	if !ok {
+		return events
+	}
+	for _, event := range notifiedEvents {
+		if j.done[event] {
+			events = append(events, event)
+		}
+	}
+	var retries []string
+	for event, done := range j.done {
+		if done && strings.HasPrefix(event, eventRetry+"-") {
+			retries = append(retries, event)
+		}
+	}
+	// Order by attempt: "retry-10" comes after "retry-9".
+	sort.Slice(retries, func(i, k int) bool {
+		if len(retries[i]) != len(retries[k]) {
+			return len(retries[i]) < len(retries[k])
+		}
+		return retries[i] < retries[k]
+	})
+	return append(events, retries...)
+}
+
+// Delete forgets everything about the Job.
+func (n *notifiedJobs) Delete(uid string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.jobs, uid)
+}
@@ -0,0 +1,26 @@
+package monitoring
+
+import "os"
+
+// DatadogConfig configures the Datadog subscription. It is read from the
+// config file and overridden by environment variables, see LoadEnv.
+type DatadogConfig struct {
+	Enabled bool `json:"enabled"`
+	// Tags are added to every service check.
+	Tags      []string `json:"tags"`
+	Namespace string   `json:"namespace"`
+}
+
+// LoadEnv overrides the config with the environment variables that are set,
+// for compatibility with deployments configured before the config file.
+func (c *DatadogConfig) LoadEnv() {
+	if v, ok := os.LookupEnv("DATADOG_ENABLE"); ok {
+		c.Enabled = v == "true"
+	}
+	if v := os.Getenv("DD_TAGS"); v != "" {
+		c.Tags = []string{v}
+	}
+	if v, ok := os.LookupEnv("DD_NAMESPACE"); ok {
+		c.Namespace = v
+	}
+}
@@ -3,13 +3,13 @@ package monitoring
 import (
 	"github.com/DataDog/datadog-go/statsd"
 	"k8s.io/klog"
-	"os"
 )
 
 const (
 	defaultStatsAddrUDS           = "unix:///var/run/datadog/dsd.socket"
 	hostName                      = "kube-job-notifier"
 	serviceCheckName              = "kube_job_notifier.job.status"
+	scheduleServiceCheckName      = "kube_job_notifier.cronjob.schedule"
 	suppressSuccessAnnotationName = "kube-job-notifier/suppress-success-datadog-subscription"
 	suppressFailedAnnotationName  = "kube-job-notifier/suppress-failed-datadog-subscription"
 )
@@ -18,19 +18,19 @@ type datadog struct {
 	client *statsd.Client
 }
 
-func newDatadog() datadog {
+func newDatadog(config DatadogConfig) datadog {
 	client, err := statsd.New(defaultStatsAddrUDS)
 	if err != nil {
 		klog.Errorf("Failed create statsd client. error: %v", err)
 	}
 
-	tags := os.Getenv("DD_TAGS")
+	tags := config.Tags
 
-	if tags != "" {
-		client.Tags = []string{tags}
+	if len(tags) > 0 {
+		client.Tags = tags
 	}
 
-	namespace := os.Getenv("DD_NAMESPACE")
+	namespace := config.Namespace
 
 	if namespace != "" {
 		client.Namespace = namespace
@@ -51,10 +51,7 @@ func (d datadog) SuccessEvent(jobInfo JobInfo) (err error) {
 		Status:   statsd.Ok,
 		Message:  "Job succeed",
 		Hostname: hostName,
-		Tags: []string{
-			"job_name:" + jobInfo.getJobName(),
-			"namespace:" + jobInfo.Namespace,
-		},
+		Tags:     jobInfo.getTags(),
 	}
 	err = d.client.ServiceCheck(sc)
 	if err != nil {
@@ -75,10 +72,34 @@ func (d datadog) FailEvent(jobInfo JobInfo) (err error) {
 		Status:   statsd.Critical,
 		Message:  "Job failed",
 		Hostname: hostName,
-		Tags: []string{
-			"job_name:" + jobInfo.getJobName(),
-			"namespace:" + jobInfo.Namespace,
-		},
+		Tags:     jobInfo.getTags(),
+	}
+	err = d.client.ServiceCheck(sc)
+	if err != nil {
+		klog.Errorf("Failed subscribe custom event. error: %v", err)
+		return err
+	}
+	klog.Infof("Event subscribe successfully %s", jobInfo.getJobName())
+	return nil
+}
+
+// ScheduleMissedEvent reports a CronJob that did not run on schedule.
+func (d datadog) ScheduleMissedEvent(jobInfo JobInfo) (err error) {
+	return d.scheduleEvent(jobInfo, statsd.Critical, "CronJob missed schedule")
+}
+
+// ScheduleResumedEvent recovers the check reported by ScheduleMissedEvent.
+func (d datadog) ScheduleResumedEvent(jobInfo JobInfo) (err error) {
+	return d.scheduleEvent(jobInfo, statsd.Ok, "CronJob resumed schedule")
+}
+
+func (d datadog) scheduleEvent(jobInfo JobInfo, status statsd.ServiceCheckStatus, message string) (err error) {
+	sc := &statsd.ServiceCheck{
+		Name:     scheduleServiceCheckName,
+		Status:   status,
+		Message:  message,
+		Hostname: hostName,
+		Tags:     jobInfo.getTags(),
 	}
 	err = d.client.ServiceCheck(sc)
 	if err != nil {
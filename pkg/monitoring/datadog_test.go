@@ -7,11 +7,19 @@ import (
 	"testing"
 )
 
+// configFromEnv returns the config set up by the test through environment
+// variables.
+func configFromEnv() DatadogConfig {
+	var config DatadogConfig
+	config.LoadEnv()
+	return config
+}
+
 func TestNewDatadog(t *testing.T) {
 	os.Setenv("DD_TAGS", "tag")
 	os.Setenv("DD_NAMESPACE", "namespace")
 
-	actual := newDatadog()
+	actual := newDatadog(configFromEnv())
 
 	assert.Equal(t, "namespace", actual.client.Namespace)
 	assert.Equal(t, []string{"tag"}, actual.client.Tags)
@@ -19,7 +27,7 @@ func TestNewDatadog(t *testing.T) {
 	os.Unsetenv("DD_TAGS")
 	os.Unsetenv("DD_NAMESPACE")
 
-	actual = newDatadog()
+	actual = newDatadog(configFromEnv())
 	assert.Empty(t, actual.client.Namespace)
 	assert.Equal(t, []string{}, actual.client.Tags)
 }
@@ -639,3 +647,20 @@ func TestDatadogAdditionalRecoveryIssues(t *testing.T) {
 		}
 	})
 }
+
+func TestJobInfoGetTags(t *testing.T) {
+	t.Run("single cluster", func(t *testing.T) {
+		jobInfo := JobInfo{Name: "test-job", CronJobName: "test-cronjob", Namespace: "default"}
+		assert.Equal(t, []string{"job_name:test-cronjob", "namespace:default"}, jobInfo.getTags())
+	})
+
+	t.Run("named cluster", func(t *testing.T) {
+		jobInfo := JobInfo{ClusterName: "prod-eu", Name: "test-job", Namespace: "default"}
+		assert.Equal(t, []string{"job_name:test-job", "namespace:default", "cluster_name:prod-eu"}, jobInfo.getTags())
+	})
+
+	t.Run("extra tags", func(t *testing.T) {
+		jobInfo := JobInfo{Name: "test-job", Namespace: "default", Tags: []string{"team:billing"}}
+		assert.Equal(t, []string{"job_name:test-job", "namespace:default", "team:billing"}, jobInfo.getTags())
+	})
+}
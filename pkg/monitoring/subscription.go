@@ -1,12 +1,27 @@
 package monitoring
 
-import "os"
-
 type JobInfo struct {
+	// ClusterName is empty when a single unnamed cluster is watched.
+	ClusterName string
 	Name        string
 	CronJobName string
 	Namespace   string
 	Annotations map[string]string
+	// Tags are added to the tags identifying the Job, e.g. those of a
+	// NotificationPolicy destination.
+	Tags []string
+}
+
+// getTags returns the tags identifying the Job.
+func (j JobInfo) getTags() []string {
+	tags := []string{
+		"job_name:" + j.getJobName(),
+		"namespace:" + j.Namespace,
+	}
+	if j.ClusterName != "" {
+		tags = append(tags, "cluster_name:"+j.ClusterName)
+	}
+	return append(tags, j.Tags...)
 }
 
 func (j JobInfo) getJobName() string {
@@ -19,13 +34,15 @@ func (j JobInfo) getJobName() string {
 type Subscription interface {
 	SuccessEvent(jobInfo JobInfo) (err error)
 	FailEvent(jobInfo JobInfo) (err error)
+	ScheduleMissedEvent(jobInfo JobInfo) (err error)
+	ScheduleResumedEvent(jobInfo JobInfo) (err error)
 }
 
 // NewSubscription Support for returning multiple event notifications in one
-func NewSubscription() map[string]Subscription {
+func NewSubscription(config DatadogConfig) map[string]Subscription {
 	res := make(map[string]Subscription)
-	if os.Getenv("DATADOG_ENABLE") == "true" {
-		res["datadog"] = newDatadog()
+	if config.Enabled {
+		res["datadog"] = newDatadog(config)
 	}
 	return res
 }
@@ -0,0 +1,185 @@
+package notification
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+)
+
+// Keys of SlackConfig.Notify.
+const (
+	notifyStarted        = "started"
+	notifySucceeded      = "succeeded"
+	notifyFailed         = "failed"
+	notifyMissedSchedule = "missedSchedule"
+	notifyLongRunning    = "longRunning"
+	notifyStuck          = "stuck"
+	notifyRetry          = "retry"
+)
+
+// slackNotifyEnv maps the keys of SlackConfig.Notify to the environment
+// variables overriding them.
+var slackNotifyEnv = map[string]string{
+	notifyStarted:        "SLACK_STARTED_NOTIFY",
+	notifySucceeded:      "SLACK_SUCCEEDED_NOTIFY",
+	notifyFailed:         "SLACK_FAILED_NOTIFY",
+	notifyMissedSchedule: "SLACK_MISSED_SCHEDULE_NOTIFY",
+	notifyLongRunning:    "SLACK_LONG_RUNNING_NOTIFY",
+	notifyStuck:          "SLACK_STUCK_NOTIFY",
+	notifyRetry:          "SLACK_RETRY_NOTIFY",
+}
+
+// Config configures the notification sinks. It is read from the config file
+// and overridden by environment variables, see LoadEnv.
+type Config struct {
+	Slack     SlackConfig     `json:"slack"`
+	MsTeamsV2 MsTeamsV2Config `json:"msteamsv2"`
+	// Receivers are additional named sinks, which only receive the
+	// notifications routed to them.
+	Receivers []ReceiverConfig `json:"receivers"`
+}
+
+type SlackConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Token    string `json:"token"`
+	Channel  string `json:"channel"`
+	Username string `json:"username"`
+	// SucceededChannel overrides Channel for start and success
+	// notifications, FailedChannel for failure and warning notifications.
+	SucceededChannel string `json:"succeededChannel"`
+	FailedChannel    string `json:"failedChannel"`
+	// Notify turns off the notifications of an event when false, e.g.
+	// {"started": false}. Events not listed are notified.
+	Notify map[string]bool `json:"notify"`
+	// Template replaces SlackMessageTemplate.
+	Template string `json:"template"`
+}
+
+type MsTeamsV2Config struct {
+	Enabled    bool   `json:"enabled"`
+	WebhookURL string `json:"webhookURL"`
+	// Template replaces TeamsMessageTemplate.
+	Template string `json:"template"`
+}
+
+// ReceiverConfig is a named sink, exactly one of Slack, MsTeamsV2 and
+// Webhook. The Enabled fields are ignored. Slack receivers default to the
+// token and username of the top-level Slack config.
+type ReceiverConfig struct {
+	Name      string           `json:"name"`
+	Slack     *SlackConfig     `json:"slack"`
+	MsTeamsV2 *MsTeamsV2Config `json:"msteamsv2"`
+	Webhook   *WebhookConfig   `json:"webhook"`
+}
+
+// WebhookConfig is a URL that every notification is posted to as a
+// WebhookPayload.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string `json:"headers"`
+}
+
+// LoadEnv overrides the config with the environment variables that are set,
+// for compatibility with deployments configured before the config file.
+func (c *Config) LoadEnv() {
+	if v, ok := os.LookupEnv("SLACK_ENABLED"); ok {
+		c.Slack.Enabled = v == "true"
+	}
+	lookupEnv("SLACK_TOKEN", &c.Slack.Token)
+	lookupEnv("SLACK_CHANNEL", &c.Slack.Channel)
+	lookupEnv("SLACK_USERNAME", &c.Slack.Username)
+	lookupEnv("SLACK_SUCCEED_CHANNEL", &c.Slack.SucceededChannel)
+	lookupEnv("SLACK_FAILED_CHANNEL", &c.Slack.FailedChannel)
+	for key, env := range slackNotifyEnv {
+		if v, ok := os.LookupEnv(env); ok {
+			if c.Slack.Notify == nil {
+				c.Slack.Notify = make(map[string]bool)
+			}
+			c.Slack.Notify[key] = v != "false"
+		}
+	}
+
+	if v, ok := os.LookupEnv("MSTEAMSV2_ENABLED"); ok {
+		c.MsTeamsV2.Enabled = v == "true"
+	}
+	lookupEnv("MSTEAMSV2_WEBHOOK_URL", &c.MsTeamsV2.WebhookURL)
+}
+
+// Validate checks that the enabled sinks are complete and that the
+// templates parse.
+func (c Config) Validate() error {
+	var errs []string
+	if c.Slack.Enabled && c.Slack.Token == "" {
+		errs = append(errs, "slack.token is required")
+	}
+	for key := range c.Slack.Notify {
+		if _, ok := slackNotifyEnv[key]; !ok {
+			errs = append(errs, fmt.Sprintf("slack.notify: unknown event %q", key))
+		}
+	}
+	if _, err := template.New("slack").Parse(c.Slack.Template); err != nil {
+		errs = append(errs, fmt.Sprintf("slack.template: %v", err))
+	}
+	if c.MsTeamsV2.Enabled && c.MsTeamsV2.WebhookURL == "" {
+		errs = append(errs, "msteamsv2.webhookURL is required")
+	}
+	if _, err := template.New("msteamsv2").Parse(c.MsTeamsV2.Template); err != nil {
+		errs = append(errs, fmt.Sprintf("msteamsv2.template: %v", err))
+	}
+	names := map[string]bool{"slack": true, "msteamsv2": true}
+	for i, r := range c.Receivers {
+		prefix := fmt.Sprintf("receivers[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, prefix+".name is required")
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Sprintf("%s.name: %q is already used", prefix, r.Name))
+		}
+		names[r.Name] = true
+		var sinks int
+		if r.Slack != nil {
+			sinks++
+			if r.Slack.Token == "" && c.Slack.Token == "" {
+				errs = append(errs, prefix+".slack.token is required")
+			}
+			if _, err := template.New("slack").Parse(r.Slack.Template); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.slack.template: %v", prefix, err))
+			}
+		}
+		if r.MsTeamsV2 != nil {
+			sinks++
+			if r.MsTeamsV2.WebhookURL == "" {
+				errs = append(errs, prefix+".msteamsv2.webhookURL is required")
+			}
+			if _, err := template.New("msteamsv2").Parse(r.MsTeamsV2.Template); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.msteamsv2.template: %v", prefix, err))
+			}
+		}
+		if r.Webhook != nil {
+			sinks++
+			if r.Webhook.URL == "" {
+				errs = append(errs, prefix+".webhook.url is required")
+			}
+		}
+		if sinks != 1 {
+			errs = append(errs, prefix+": exactly one of slack, msteamsv2 and webhook is required")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// isNotify reports whether the event is notified, see SlackConfig.Notify.
+func (c SlackConfig) isNotify(key string) bool {
+	notify, ok := c.Notify[key]
+	return !ok || notify
+}
+
+func lookupEnv(key string, value *string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*value = v
+	}
+}
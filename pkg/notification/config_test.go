@@ -0,0 +1,86 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestConfigLoadEnv(t *testing.T) {
+	config := Config{
+		Slack: SlackConfig{
+			Enabled: true,
+			Token:   "xoxb-from-file",
+			Channel: "C0123456789",
+			Notify:  map[string]bool{notifyStarted: false},
+		},
+	}
+	t.Setenv("SLACK_TOKEN", "xoxb-from-env")
+	t.Setenv("SLACK_STARTED_NOTIFY", "true")
+	t.Setenv("SLACK_RETRY_NOTIFY", "false")
+	t.Setenv("MSTEAMSV2_ENABLED", "true")
+	config.LoadEnv()
+
+	assert.True(t, config.Slack.Enabled)
+	assert.Equal(t, "xoxb-from-env", config.Slack.Token)
+	assert.Equal(t, "C0123456789", config.Slack.Channel)
+	assert.True(t, config.Slack.isNotify(notifyStarted))
+	assert.False(t, config.Slack.isNotify(notifyRetry))
+	assert.True(t, config.Slack.isNotify(notifyFailed))
+	assert.True(t, config.MsTeamsV2.Enabled)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.NoError(t, Config{Slack: SlackConfig{Enabled: true, Token: "xoxb", Template: "{{.JobName}}"}}.Validate())
+
+	err := Config{
+		Slack:     SlackConfig{Enabled: true, Notify: map[string]bool{"finished": false}},
+		MsTeamsV2: MsTeamsV2Config{Enabled: true, Template: "{{.JobName"},
+	}.Validate()
+	assert.ErrorContains(t, err, "slack.token is required")
+	assert.ErrorContains(t, err, `slack.notify: unknown event "finished"`)
+	assert.ErrorContains(t, err, "msteamsv2.webhookURL is required")
+	assert.ErrorContains(t, err, "msteamsv2.template")
+
+	err = Config{Receivers: []ReceiverConfig{
+		{Name: "slack", Webhook: &WebhookConfig{URL: "https://example.com/hook"}},
+		{Name: "billing", Slack: &SlackConfig{Channel: "#billing"}},
+		{Name: "both", Webhook: &WebhookConfig{URL: "https://example.com/hook"}, MsTeamsV2: &MsTeamsV2Config{WebhookURL: "https://example.com/teams"}},
+		{Webhook: &WebhookConfig{}},
+	}}.Validate()
+	assert.ErrorContains(t, err, `receivers[0].name: "slack" is already used`)
+	assert.ErrorContains(t, err, "receivers[1].slack.token is required")
+	assert.ErrorContains(t, err, "receivers[2]: exactly one of slack, msteamsv2 and webhook is required")
+	assert.ErrorContains(t, err, "receivers[3].name is required")
+	assert.ErrorContains(t, err, "receivers[3].webhook.url is required")
+
+	assert.NoError(t, Config{
+		Slack:     SlackConfig{Token: "xoxb"},
+		Receivers: []ReceiverConfig{{Name: "billing", Slack: &SlackConfig{Channel: "#billing"}}},
+	}.Validate(), "slack receivers default to the top-level token")
+}
+
+func TestSlackTemplate(t *testing.T) {
+	mc := &MockSlackClient{}
+	mc.On("PostMessage", "C0123456789", mock.AnythingOfType("[]slack.MsgOption")).
+		Return("C0123456789", "timestamp", nil)
+	mc.On("GetPermalinkContext", mock.Anything, mock.Anything).Return("", nil)
+	s := slack{client: mc, channel: "C0123456789", config: SlackConfig{Template: "Job {{.JobName}} started"}}
+
+	message, err := s.getMessage(MessageTemplateParam{JobName: "the-job"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Job the-job started", message)
+
+	_, err = s.NotifyStart(MessageTemplateParam{JobName: "the-job"})
+	assert.NoError(t, err)
+	mc.AssertExpectations(t)
+}
+
+func TestMsTeamsV2Template(t *testing.T) {
+	m := MsTeamsV2{template: "**Job** {{.JobName}}"}
+	message, err := m.getMessage(MessageTemplateParam{JobName: "the-job"})
+	assert.NoError(t, err)
+	assert.Equal(t, "**Job** the-job", message)
+}
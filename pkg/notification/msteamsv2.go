@@ -4,10 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 
 	"k8s.io/klog"
@@ -18,13 +16,39 @@ const (
 	colorGreen = "Good"
 	colorGrey  = "Warning"
 
+	// MsTeamsV2WebhookURLAnnotationName overrides MSTEAMSV2_WEBHOOK_URL, e.g.
+	// to route all Jobs of a namespace to the channel of its team. The
+	// controller only reads it from the Namespace, and does not apply it to
+	// the webhook URLs of receivers and NotificationPolicies.
+	MsTeamsV2WebhookURLAnnotationName = "kube-job-notifier/msteamsv2-webhook-url"
+
 	TeamsMessageTemplate = `
-{{if .CronJobName}}**CronJobName**: {{.CronJobName}}{{end}}
-**JobName**: {{.JobName}}
+{{if .ClusterName}}**ClusterName**: {{.ClusterName}}
+{{end}}{{if .CronJobName}}**CronJobName**: {{.CronJobName}}{{end}}{{if .CronJobSuspended}}
+**Suspended**: true{{end}}{{if .CronJobSchedule}}
+**Schedule**: {{.CronJobSchedule}}{{if .CronJobTimeZone}} ({{.CronJobTimeZone}}){{end}}{{end}}{{if .LastSuccessfulTime}}
+**LastSuccessfulTime**: {{.LastSuccessfulTime.Format "2006-01-02 15:04:05 -07:00"}}{{end}}{{if .LastScheduleTime}}
+**LastScheduleTime**: {{.LastScheduleTime.Format "2006-01-02 15:04:05 -07:00"}}{{end}}{{if .ExpectedScheduleTime}}
+**ExpectedScheduleTime**: {{.ExpectedScheduleTime.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
+{{if .JobName}}**JobName**: {{.JobName}}{{end}}
 {{if .Namespace}}**Namespace**: {{.Namespace}}{{end}}
 {{if .StartTime }}**StartTime**: {{.StartTime.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
 {{if .CompletionTime }}**CompletionTime**: {{.CompletionTime.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
-{{if .ExecutionTime }}**ExecutionTime**: {{.ExecutionTime}}{{end}}`
+{{if .ExecutionTime }}**ExecutionTime**: {{.ExecutionTime}}{{end}}{{if .ExpectedDuration}}
+**ExpectedDuration**: {{.ExpectedDuration}}{{end}}{{if .PodStatus}}
+**PodStatus**: {{.PodStatus}}{{end}}{{if .StuckReason}}
+**StuckReason**: {{.StuckReason}}{{end}}{{if .StuckMessage}}
+**StuckMessage**: {{.StuckMessage}}{{end}}{{if .FailureReason}}
+**Reason**: {{.FailureReason}}{{end}}{{if .FailureMessage}}
+**Message**: {{.FailureMessage}}{{end}}{{range .ContainerFailures}}
+**Container**: {{.Name}} {{with .Reason}}{{.}} {{end}}(exit {{.ExitCode}}{{if .Signal}}, signal {{.Signal}}{{end}}){{if .Message}}
+**TerminationMessage**: {{.Message}}{{end}}{{end}}{{range .Events}}
+**Event**: {{.Type}} {{.Reason}} {{.Object}}{{if gt .Count 1}} (x{{.Count}}){{end}}: {{.Message}}{{end}}{{if .LogExcerpt}}
+**LogExcerpt**:
+` + "```" + `
+{{.LogExcerpt}}
+` + "```" + `{{end}}{{range .Grouped}}
+**Grouped**: {{.Event}} {{.Namespace}}/{{if .JobName}}{{.JobName}}{{else}}{{.CronJobName}}{{end}}{{end}}`
 )
 
 // https://learn.microsoft.com/en-us/connectors/teams/?tabs=text1#adaptivecarditemschema
@@ -63,53 +87,91 @@ type TeamsMessage struct {
 
 type MsTeamsV2 struct {
 	webhookURL string
+	template   string
+	client     *http.Client
 }
 
-func newMsTeamsV2() (MsTeamsV2, error) {
-	webhookURL := os.Getenv("MSTEAMSV2_WEBHOOK_URL")
+func newMsTeamsV2(config MsTeamsV2Config) (MsTeamsV2, error) {
+	webhookURL := config.WebhookURL
 	if webhookURL == "" {
 		return MsTeamsV2{}, fmt.Errorf("please set webhook URL for MSTeamsV2")
 	}
 	return MsTeamsV2{
 		webhookURL: webhookURL,
+		template:   config.Template,
+		client:     &http.Client{Timeout: webhookTimeout},
 	}, nil
 }
 
 func getTeamsMessage(messageParam MessageTemplateParam) (slackMessage string, err error) {
-	var b bytes.Buffer
-	tpl, err := template.New("teams").Parse(TeamsMessageTemplate)
-	if err != nil {
-		return "", err
-	}
-	err = tpl.Execute(&b, messageParam)
-	if err != nil {
-		return "", err
+	return executeTemplate("teams", TeamsMessageTemplate, messageParam)
+}
+
+// getMessage renders the configured template, or TeamsMessageTemplate.
+func (m MsTeamsV2) getMessage(messageParam MessageTemplateParam) (string, error) {
+	if m.template == "" {
+		return getTeamsMessage(messageParam)
 	}
-	return b.String(), nil
+	return executeTemplate("teams", m.template, messageParam)
 }
 
 // NotifyStart implements Notification.
-func (m MsTeamsV2) NotifyStart(messageParam MessageTemplateParam) (err error) {
+func (m MsTeamsV2) NotifyStart(messageParam MessageTemplateParam) (*Delivery, error) {
 
-	return m.SendNotification("Job Start", messageParam, colorGrey)
+	return m.send("Job Start", messageParam, colorGrey)
 }
 
 // NotifySuccess implements Notification.
-func (m MsTeamsV2) NotifySuccess(messageParam MessageTemplateParam) (err error) {
+func (m MsTeamsV2) NotifySuccess(messageParam MessageTemplateParam) (*Delivery, error) {
 	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
 
-	return m.SendNotification("Job Succeeded", messageParam, colorGreen)
+	return m.send("Job Succeeded", messageParam, colorGreen)
 }
 
 // NotifyFailed implements Notification.
-func (m MsTeamsV2) NotifyFailed(messageParam MessageTemplateParam) (err error) {
+func (m MsTeamsV2) NotifyFailed(messageParam MessageTemplateParam) (*Delivery, error) {
 	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
 
-	return m.SendNotification("Job Failed", messageParam, colorRed)
+	return m.send("Job Failed", messageParam, colorRed)
+}
+
+// NotifyMissedSchedule implements Notification.
+func (m MsTeamsV2) NotifyMissedSchedule(messageParam MessageTemplateParam) (*Delivery, error) {
+
+	return m.send("Missed Schedule", messageParam, colorGrey)
+}
+
+// NotifyStuck implements Notification.
+func (m MsTeamsV2) NotifyStuck(messageParam MessageTemplateParam) (*Delivery, error) {
+
+	return m.send("Job Stuck", messageParam, colorRed)
+}
+
+// NotifyRetry implements Notification.
+func (m MsTeamsV2) NotifyRetry(messageParam MessageTemplateParam) (*Delivery, error) {
+
+	return m.send(messageParam.getRetryTitle(), messageParam, colorGrey)
+}
+
+// NotifyLongRunning implements Notification.
+func (m MsTeamsV2) NotifyLongRunning(messageParam MessageTemplateParam) (*Delivery, error) {
+	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
+	messageParam.CompletionTime = nil
+
+	return m.send("Job Running Longer Than Expected", messageParam, colorGrey)
+}
+
+// send sends the notification. The Delivery is empty, as webhooks do not
+// identify the posted message.
+func (m MsTeamsV2) send(title string, messageParam MessageTemplateParam, color string) (*Delivery, error) {
+	if err := m.SendNotification(title, messageParam, color); err != nil {
+		return nil, err
+	}
+	return &Delivery{}, nil
 }
 
 func (m MsTeamsV2) SendNotification(title string, messageParam MessageTemplateParam, color string) (err error) {
-	message, err := getTeamsMessage(messageParam)
+	message, err := m.getMessage(messageParam)
 	if err != nil {
 		klog.Errorf("Template execute failed %s\n", err)
 		return err
@@ -124,7 +186,11 @@ func (m MsTeamsV2) SendNotification(title string, messageParam MessageTemplatePa
 
 	body = &payload
 
-	resp, err := http.Post(m.webhookURL, "application/json", body)
+	webhookURL := m.webhookURL
+	if url := messageParam.Annotations[MsTeamsV2WebhookURLAnnotationName]; url != "" {
+		webhookURL = url
+	}
+	resp, err := m.client.Post(webhookURL, "application/json", body)
 	if err != nil {
 		return err
 	}
@@ -136,6 +202,23 @@ func (m MsTeamsV2) SendNotification(title string, messageParam MessageTemplatePa
 	return nil
 }
 
+// WithoutMsTeamsV2WebhookURL returns a copy of the message without the
+// webhook URL override, for Teams sinks whose webhook URL is explicitly
+// configured, e.g. by a NotificationPolicy.
+func (m MessageTemplateParam) WithoutMsTeamsV2WebhookURL() MessageTemplateParam {
+	if _, ok := m.Annotations[MsTeamsV2WebhookURLAnnotationName]; !ok {
+		return m
+	}
+	annotations := make(map[string]string, len(m.Annotations))
+	for k, v := range m.Annotations {
+		if k != MsTeamsV2WebhookURLAnnotationName {
+			annotations[k] = v
+		}
+	}
+	m.Annotations = annotations
+	return m
+}
+
 func (m MsTeamsV2) GetTeamsPayload(title string, text string, color string) TeamsMessage {
 	// Replace single line breaks with double for Adaptive Cards
 	formattedText := strings.ReplaceAll(text, "\n", "\n\n")
@@ -17,16 +17,17 @@ func TestNewMsTeamsV2(t *testing.T) {
 	t.Run("should create MsTeamsV2 with webhook URL", func(t *testing.T) {
 		t.Setenv("MSTEAMSV2_WEBHOOK_URL", "https://example.com/webhook")
 
-		msTeams, err := newMsTeamsV2()
+		msTeams, err := newMsTeamsV2(configFromEnv().MsTeamsV2)
 
 		assert.NoError(t, err)
 		assert.Equal(t, "https://example.com/webhook", msTeams.webhookURL)
+		assert.Equal(t, webhookTimeout, msTeams.client.Timeout)
 	})
 
 	t.Run("should return error when webhook URL is not set", func(t *testing.T) {
 		t.Setenv("MSTEAMSV2_WEBHOOK_URL", "")
 
-		_, err := newMsTeamsV2()
+		_, err := newMsTeamsV2(configFromEnv().MsTeamsV2)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "please set webhook URL for MSTeamsV2")
 	})
@@ -75,11 +76,93 @@ func TestGetTeamsMessageWithoutCronJob(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.NotContains(t, message, "**CronJobName**:")
+	assert.NotContains(t, message, "**Schedule**:")
 	assert.Contains(t, message, "**JobName**: test-job")
 }
 
+func TestGetTeamsMessageWithFailureReason(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		JobName:       "test-job",
+		FailureReason: "DeadlineExceeded",
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "**Reason**: DeadlineExceeded")
+	assert.NotContains(t, message, "**Message**:")
+}
+
+func TestGetTeamsMessageWithContainerFailures(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		JobName: "test-job",
+		ContainerFailures: []ContainerFailure{
+			{Name: "app", Reason: "Error", ExitCode: 1, Message: "database is unreachable"},
+		},
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "**Container**: app Error (exit 1)\n**TerminationMessage**: database is unreachable")
+}
+
+func TestGetTeamsMessageWithEvents(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		JobName: "test-job",
+		Events: []JobEvent{
+			{Type: "Warning", Reason: "Evicted", Object: "Pod/test-job-x2k4f", Message: "The node was low on resource: memory.", Count: 1},
+		},
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "**Event**: Warning Evicted Pod/test-job-x2k4f: The node was low on resource: memory.")
+}
+
+func TestGetTeamsMessageWithGrouped(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		JobName: "nightly-3",
+		Grouped: []GroupedEvent{
+			{Event: "failed", Namespace: "billing", CronJobName: "nightly", JobName: "nightly-2"},
+			{Event: "missed-schedule", Namespace: "billing", CronJobName: "hourly"},
+		},
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "\n**Grouped**: failed billing/nightly-2\n**Grouped**: missed-schedule billing/hourly")
+}
+
+func TestGetTeamsMessageWithClusterName(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		ClusterName: "prod-eu",
+		JobName:     "test-job",
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "**ClusterName**: prod-eu\n")
+}
+
+func TestGetTeamsMessageWithCronJobSchedule(t *testing.T) {
+	messageParam := MessageTemplateParam{
+		JobName:         "test-job",
+		CronJobName:     "test-cronjob",
+		CronJobSchedule: "0 * * * *",
+	}
+
+	message, err := getTeamsMessage(messageParam)
+
+	assert.NoError(t, err)
+	assert.Contains(t, message, "**CronJobName**: test-cronjob\n**Schedule**: 0 * * * *\n")
+}
+
 func TestMsTeamsV2_GetTeamsPayload(t *testing.T) {
-	msTeams := MsTeamsV2{webhookURL: "https://example.com/webhook"}
+	msTeams := MsTeamsV2{webhookURL: "https://example.com/webhook", client: http.DefaultClient}
 
 	t.Run("should create payload with correct structure", func(t *testing.T) {
 		payload := msTeams.GetTeamsPayload("Test Title", "Test Message", colorGreen)
@@ -146,7 +229,7 @@ func TestMsTeamsV2_NotifyStart(t *testing.T) {
 	}))
 	defer server.Close()
 
-	msTeams := MsTeamsV2{webhookURL: server.URL}
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
 	startTime := &metav1.Time{Time: mockTime}
 
 	messageParam := MessageTemplateParam{
@@ -155,9 +238,10 @@ func TestMsTeamsV2_NotifyStart(t *testing.T) {
 		StartTime: startTime,
 	}
 
-	err := msTeams.NotifyStart(messageParam)
+	delivery, err := msTeams.NotifyStart(messageParam)
 
 	assert.NoError(t, err)
+	assert.Equal(t, &Delivery{}, delivery)
 	assert.Equal(t, "Job Start", receivedPayload.Attachments[0].Content.Body[0].Text)
 	assert.Equal(t, colorGrey, receivedPayload.Attachments[0].Content.Body[0].Color)
 }
@@ -175,7 +259,7 @@ func TestMsTeamsV2_NotifySuccess(t *testing.T) {
 	}))
 	defer server.Close()
 
-	msTeams := MsTeamsV2{webhookURL: server.URL}
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
 	startTime := &metav1.Time{Time: mockTime}
 	completionTime := &metav1.Time{Time: startTime.Add(1 * time.Minute)}
 
@@ -186,7 +270,7 @@ func TestMsTeamsV2_NotifySuccess(t *testing.T) {
 		CompletionTime: completionTime,
 	}
 
-	err := msTeams.NotifySuccess(messageParam)
+	_, err := msTeams.NotifySuccess(messageParam)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "Job Succeeded", receivedPayload.Attachments[0].Content.Body[0].Text)
@@ -207,7 +291,7 @@ func TestMsTeamsV2_NotifyFailed(t *testing.T) {
 	}))
 	defer server.Close()
 
-	msTeams := MsTeamsV2{webhookURL: server.URL}
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
 	startTime := &metav1.Time{Time: mockTime}
 	completionTime := &metav1.Time{Time: startTime.Add(1 * time.Minute)}
 
@@ -218,7 +302,7 @@ func TestMsTeamsV2_NotifyFailed(t *testing.T) {
 		CompletionTime: completionTime,
 	}
 
-	err := msTeams.NotifyFailed(messageParam)
+	_, err := msTeams.NotifyFailed(messageParam)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "Job Failed", receivedPayload.Attachments[0].Content.Body[0].Text)
@@ -226,11 +310,152 @@ func TestMsTeamsV2_NotifyFailed(t *testing.T) {
 	assert.Contains(t, receivedPayload.Attachments[0].Content.Body[1].Text, "**ExecutionTime**:")
 }
 
+func TestMsTeamsV2_NotifyMissedSchedule(t *testing.T) {
+	var receivedPayload TeamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
+	expected := &metav1.Time{Time: time.Date(2020, 11, 28, 1, 0, 0, 0, time.UTC)}
+
+	messageParam := MessageTemplateParam{
+		CronJobName:          "test-cronjob",
+		Namespace:            "default",
+		CronJobSchedule:      "0 * * * *",
+		ExpectedScheduleTime: expected,
+	}
+
+	_, err := msTeams.NotifyMissedSchedule(messageParam)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Missed Schedule", receivedPayload.Attachments[0].Content.Body[0].Text)
+	assert.Equal(t, colorGrey, receivedPayload.Attachments[0].Content.Body[0].Color)
+	assert.Contains(t, receivedPayload.Attachments[0].Content.Body[1].Text, "**ExpectedScheduleTime**: 2020-11-28 01:00:00")
+	assert.NotContains(t, receivedPayload.Attachments[0].Content.Body[1].Text, "**JobName**:")
+}
+
+func TestMsTeamsV2_NotifyLongRunning(t *testing.T) {
+	mockTime := time.Date(2020, 11, 28, 1, 2, 3, 123456000, time.UTC)
+	restore := flextime.Set(mockTime)
+	defer restore()
+
+	var receivedPayload TeamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
+
+	messageParam := MessageTemplateParam{
+		JobName:          "test-job",
+		Namespace:        "default",
+		StartTime:        &metav1.Time{Time: mockTime.Add(-45 * time.Minute)},
+		ExpectedDuration: 30 * time.Minute,
+		PodStatus:        "Running",
+	}
+
+	_, err := msTeams.NotifyLongRunning(messageParam)
+
+	assert.NoError(t, err)
+	text := receivedPayload.Attachments[0].Content.Body[1].Text
+	assert.Equal(t, "Job Running Longer Than Expected", receivedPayload.Attachments[0].Content.Body[0].Text)
+	assert.Contains(t, text, "**ExecutionTime**: 45m0s")
+	assert.Contains(t, text, "**ExpectedDuration**: 30m0s")
+	assert.Contains(t, text, "**PodStatus**: Running")
+	assert.NotContains(t, text, "**CompletionTime**:")
+}
+
+func TestMsTeamsV2_NotifyStuck(t *testing.T) {
+	var receivedPayload TeamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
+
+	messageParam := MessageTemplateParam{
+		JobName:      "test-job",
+		Namespace:    "default",
+		StuckReason:  "Unschedulable",
+		StuckMessage: "0/12 nodes are available: insufficient memory",
+	}
+
+	_, err := msTeams.NotifyStuck(messageParam)
+
+	assert.NoError(t, err)
+	text := receivedPayload.Attachments[0].Content.Body[1].Text
+	assert.Equal(t, "Job Stuck", receivedPayload.Attachments[0].Content.Body[0].Text)
+	assert.Equal(t, colorRed, receivedPayload.Attachments[0].Content.Body[0].Color)
+	assert.Contains(t, text, "**StuckReason**: Unschedulable")
+	assert.Contains(t, text, "**StuckMessage**: 0/12 nodes are available: insufficient memory")
+}
+
+func TestMsTeamsV2_NotifyRetry(t *testing.T) {
+	var receivedPayload TeamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
+
+	messageParam := MessageTemplateParam{
+		JobName:           "test-job",
+		Namespace:         "default",
+		Attempt:           2,
+		MaxAttempts:       6,
+		ContainerFailures: []ContainerFailure{{Name: "app", Reason: "OOMKilled", ExitCode: 137}},
+		LogExcerpt:        "connection refused",
+	}
+
+	_, err := msTeams.NotifyRetry(messageParam)
+
+	assert.NoError(t, err)
+	text := receivedPayload.Attachments[0].Content.Body[1].Text
+	assert.Equal(t, "Retrying: attempt 2/6", receivedPayload.Attachments[0].Content.Body[0].Text)
+	assert.Contains(t, text, "**Container**: app OOMKilled (exit 137)")
+	assert.Contains(t, text, "connection refused")
+}
+
+func TestMsTeamsV2_SendNotificationWithWebhookAnnotation(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	msTeams := MsTeamsV2{webhookURL: "http://127.0.0.1:0/unused", client: http.DefaultClient}
+
+	messageParam := MessageTemplateParam{
+		JobName:     "test-job",
+		Namespace:   "default",
+		Annotations: map[string]string{MsTeamsV2WebhookURLAnnotationName: server.URL},
+	}
+
+	err := msTeams.SendNotification("Test", messageParam, colorGreen)
+
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
+
 func TestMsTeamsV2_SendNotificationError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	server.Close() // Close immediately so the request fails with connection refused
 
-	msTeams := MsTeamsV2{webhookURL: server.URL}
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
 
 	messageParam := MessageTemplateParam{
 		JobName:   "test-job",
@@ -248,7 +473,7 @@ func TestMsTeamsV2_SendNotificationWithHTTPError(t *testing.T) {
 	}))
 	defer server.Close()
 
-	msTeams := MsTeamsV2{webhookURL: server.URL}
+	msTeams := MsTeamsV2{webhookURL: server.URL, client: http.DefaultClient}
 
 	messageParam := MessageTemplateParam{
 		JobName:   "test-job",
@@ -260,3 +485,15 @@ func TestMsTeamsV2_SendNotificationWithHTTPError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "500")
 }
+
+func TestWithoutMsTeamsV2WebhookURL(t *testing.T) {
+	annotations := map[string]string{
+		MsTeamsV2WebhookURLAnnotationName:   "https://example.com/namespace",
+		"kube-job-notifier/default-channel": "job-alerts",
+	}
+	param := MessageTemplateParam{JobName: "job", Annotations: annotations}.WithoutMsTeamsV2WebhookURL()
+
+	assert.Equal(t, map[string]string{"kube-job-notifier/default-channel": "job-alerts"}, param.Annotations)
+	assert.Equal(t, "https://example.com/namespace", annotations[MsTeamsV2WebhookURLAnnotationName],
+		"the original annotations are unchanged")
+}
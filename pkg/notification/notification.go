@@ -1,8 +1,10 @@
 package notification
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"html/template"
+	"strings"
 	"time"
 
 	"github.com/Songmu/flextime"
@@ -10,6 +12,8 @@ import (
 )
 
 type MessageTemplateParam struct {
+	// ClusterName is empty when a single unnamed cluster is watched.
+	ClusterName    string
 	JobName        string
 	CronJobName    string
 	Namespace      string
@@ -18,6 +22,120 @@ type MessageTemplateParam struct {
 	ExecutionTime  time.Duration
 	Log            string
 	Annotations    map[string]string
+
+	// Reason and message of the Job condition that marked it as failed,
+	// e.g. BackoffLimitExceeded, DeadlineExceeded or PodFailurePolicy.
+	FailureReason  string
+	FailureMessage string
+
+	// Fields of the owning CronJob, empty when the Job has no CronJob owner.
+	CronJobSchedule    string
+	CronJobTimeZone    string
+	CronJobSuspended   bool
+	LastSuccessfulTime *metav1.Time
+
+	// Fields of a missed CronJob schedule.
+	LastScheduleTime     *metav1.Time
+	ExpectedScheduleTime *metav1.Time
+
+	// Fields of a Job running longer than expected.
+	ExpectedDuration time.Duration
+	PodStatus        string
+
+	// Reason and message of a Pod stuck in Pending, e.g. ImagePullBackOff or
+	// Unschedulable with "0/12 nodes are available: insufficient memory".
+	StuckReason  string
+	StuckMessage string
+
+	// Containers of the failed Pod that terminated unsuccessfully.
+	ContainerFailures []ContainerFailure
+	// Most recent Kubernetes Events of the failed Job and its Pods.
+	Events []JobEvent
+
+	// Fields of a failed attempt that is retried. Attempt is the attempt about
+	// to run and MaxAttempts is backoffLimit + 1.
+	Attempt     int
+	MaxAttempts int
+	LogExcerpt  string
+
+	// Other events of the same route group, held back since the previous
+	// notification of the group and sent with this one.
+	Grouped []GroupedEvent
+}
+
+// ContainerFailure is the terminated state of a failed container.
+type ContainerFailure struct {
+	Name string
+	// Reason is e.g. Error or OOMKilled.
+	Reason   string
+	ExitCode int32
+	Signal   int32
+	// Message is the content of the container's terminationMessagePath.
+	Message string
+}
+
+// GroupedEvent is an event sent with the notification of another event of
+// its route group.
+type GroupedEvent struct {
+	// Event is one of start, success, failed, missed-schedule, long-running,
+	// stuck and retry.
+	Event       string
+	Namespace   string
+	CronJobName string
+	JobName     string
+}
+
+// JobEvent is a Kubernetes Event of a Job or one of its Pods.
+type JobEvent struct {
+	Type   string
+	Reason string
+	// Object is the kind and name of the involved object, e.g. "Pod/my-job-x2k4f".
+	Object   string
+	Message  string
+	Count    int32
+	LastSeen time.Time
+}
+
+// getName returns the name the message is about, for logging.
+func (m MessageTemplateParam) getName() string {
+	if m.JobName != "" {
+		return m.JobName
+	}
+	return m.CronJobName
+}
+
+// getRetryTitle returns the title of a retry notification.
+func (m MessageTemplateParam) getRetryTitle() string {
+	return fmt.Sprintf("Retrying: attempt %d/%d", m.Attempt, m.MaxAttempts)
+}
+
+// getLogFileContent returns the log followed by the Events, for uploading as
+// a file.
+func (m MessageTemplateParam) getLogFileContent() string {
+	if len(m.Events) == 0 {
+		return m.Log
+	}
+	var b strings.Builder
+	b.WriteString(m.Log)
+	b.WriteString("\n\nEvents:\n")
+	for _, e := range m.Events {
+		fmt.Fprintf(&b, "%s %s %s %s (x%d): %s\n",
+			e.LastSeen.UTC().Format(time.RFC3339), e.Type, e.Reason, e.Object, e.Count, e.Message)
+	}
+	return b.String()
+}
+
+// executeTemplate renders the message template with html/template.
+func executeTemplate(name, text string, messageParam MessageTemplateParam) (string, error) {
+	tpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err = tpl.Execute(&b, messageParam); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
 func (m MessageTemplateParam) calculateExecutionTime() (completionTime *metav1.Time, executionTime time.Duration) {
@@ -31,27 +149,70 @@ func (m MessageTemplateParam) calculateExecutionTime() (completionTime *metav1.T
 	return completionTime, executionTime.Truncate(time.Second)
 }
 
+// Delivery identifies a delivered notification. Notify methods return a nil
+// Delivery when the notification is disabled or suppressed.
+type Delivery struct {
+	// Channel and Timestamp identify the Slack message. They are empty for
+	// sinks that do not identify posted messages.
+	Channel   string
+	Timestamp string
+	// Permalink is a link to the Slack message.
+	Permalink string
+}
+
 type Notification interface {
-	NotifyStart(messageParam MessageTemplateParam) (err error)
-	NotifySuccess(messageParam MessageTemplateParam) (err error)
-	NotifyFailed(messageParam MessageTemplateParam) (err error)
+	NotifyStart(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifySuccess(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifyFailed(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifyMissedSchedule(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifyLongRunning(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifyStuck(messageParam MessageTemplateParam) (delivery *Delivery, err error)
+	NotifyRetry(messageParam MessageTemplateParam) (delivery *Delivery, err error)
 }
 
-func NewNotifications() (map[string]Notification, error) {
+func NewNotifications(config Config) (map[string]Notification, error) {
 	res := make(map[string]Notification)
-	if os.Getenv("SLACK_ENABLED") == "true" {
-		s, err := newSlack()
+	if config.Slack.Enabled {
+		s, err := newSlack(config.Slack)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create slack notification: %w", err)
 		}
 		res["slack"] = s
 	}
-	if os.Getenv("MSTEAMSV2_ENABLED") == "true" {
-		m, err := newMsTeamsV2()
+	if config.MsTeamsV2.Enabled {
+		m, err := newMsTeamsV2(config.MsTeamsV2)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create msteamsv2 notification: %w", err)
 		}
 		res["msteamsv2"] = m
 	}
+	for _, r := range config.Receivers {
+		n, err := newReceiver(r, config.Slack)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create receiver %s: %w", r.Name, err)
+		}
+		res[r.Name] = n
+	}
 	return res, nil
 }
+
+// newReceiver creates the sink of the receiver. Slack receivers default to
+// the token and username of slackConfig.
+func newReceiver(config ReceiverConfig, slackConfig SlackConfig) (Notification, error) {
+	switch {
+	case config.Slack != nil:
+		c := *config.Slack
+		if c.Token == "" {
+			c.Token = slackConfig.Token
+		}
+		if c.Username == "" {
+			c.Username = slackConfig.Username
+		}
+		return newSlack(c)
+	case config.MsTeamsV2 != nil:
+		return newMsTeamsV2(*config.MsTeamsV2)
+	case config.Webhook != nil:
+		return newWebhook(*config.Webhook)
+	}
+	return nil, fmt.Errorf("exactly one of slack, msteamsv2 and webhook is required")
+}
@@ -1,34 +1,57 @@
 package notification
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
-	"os"
 
 	slackapi "github.com/slack-go/slack"
 	"k8s.io/klog"
 )
 
-
 const (
 	SlackMessageTemplate = `
-{{if .CronJobName}} *CronJobName*: {{.CronJobName}}{{end}}
- *JobName*: {{.JobName}}
+{{if .ClusterName}} *ClusterName*: {{.ClusterName}}
+{{end}}{{if .CronJobName}} *CronJobName*: {{.CronJobName}}{{end}}{{if .CronJobSuspended}}
+ *Suspended*: true{{end}}{{if .CronJobSchedule}}
+ *Schedule*: {{.CronJobSchedule}}{{if .CronJobTimeZone}} ({{.CronJobTimeZone}}){{end}}{{end}}{{if .LastSuccessfulTime}}
+ *LastSuccessfulTime*: {{.LastSuccessfulTime.Format "2006/1/2 15:04:05 UTC"}}{{end}}{{if .LastScheduleTime}}
+ *LastScheduleTime*: {{.LastScheduleTime.Format "2006/1/2 15:04:05 UTC"}}{{end}}{{if .ExpectedScheduleTime}}
+ *ExpectedScheduleTime*: {{.ExpectedScheduleTime.Format "2006/1/2 15:04:05 UTC"}}{{end}}
+{{if .JobName}} *JobName*: {{.JobName}}{{end}}
 {{if .Namespace}} *Namespace*: {{.Namespace}}{{end}}
 {{if .StartTime }} *StartTime*: {{.StartTime.Format "2006/1/2 15:04:05 UTC"}}{{end}}
 {{if .CompletionTime }} *CompletionTime*: {{.CompletionTime.Format "2006/1/2 15:04:05 UTC"}}{{end}}
-{{if .ExecutionTime }} *ExecutionTime*: {{.ExecutionTime}}{{end}}
+{{if .ExecutionTime }} *ExecutionTime*: {{.ExecutionTime}}{{end}}{{if .ExpectedDuration}}
+ *ExpectedDuration*: {{.ExpectedDuration}}{{end}}{{if .PodStatus}}
+ *PodStatus*: {{.PodStatus}}{{end}}{{if .StuckReason}}
+ *StuckReason*: {{.StuckReason}}{{end}}{{if .StuckMessage}}
+ *StuckMessage*: {{.StuckMessage}}{{end}}{{if .FailureReason}}
+ *Reason*: {{.FailureReason}}{{end}}{{if .FailureMessage}}
+ *Message*: {{.FailureMessage}}{{end}}{{range .ContainerFailures}}
+ *Container*: {{.Name}} {{with .Reason}}{{.}} {{end}}(exit {{.ExitCode}}{{if .Signal}}, signal {{.Signal}}{{end}}){{if .Message}}
+ *TerminationMessage*: {{.Message}}{{end}}{{end}}{{range .Events}}
+ *Event*: {{.Type}} {{.Reason}} {{.Object}}{{if gt .Count 1}} (x{{.Count}}){{end}}: {{.Message}}{{end}}{{if .LogExcerpt}}
+ *LogExcerpt*:
+` + "```" + `{{.LogExcerpt}}` + "```" + `{{end}}{{range .Grouped}}
+ *Grouped*: {{.Event}} {{.Namespace}}/{{if .JobName}}{{.JobName}}{{else}}{{.CronJobName}}{{end}}{{end}}
 {{if .Log }} *Loglink*: {{.Log}}{{end}}`
 
 	defaultAnnotationName         = "kube-job-notifier/default-channel"
 	successAnnotationName         = "kube-job-notifier/success-channel"
 	startedAnnotationName         = "kube-job-notifier/started-channel"
 	failedAnnotationName          = "kube-job-notifier/failed-channel"
+	missedAnnotationName          = "kube-job-notifier/missed-schedule-channel"
+	longRunningAnnotationName     = "kube-job-notifier/long-running-channel"
+	stuckAnnotationName           = "kube-job-notifier/stuck-channel"
+	retryAnnotationName           = "kube-job-notifier/retry-channel"
 	suppressSuccessAnnotationName = "kube-job-notifier/suppress-success-notification"
 	suppressStartedAnnotationName = "kube-job-notifier/suppress-started-notification"
 	suppressFailedAnnotationName  = "kube-job-notifier/suppress-failed-notification"
+	suppressMissedAnnotationName  = "kube-job-notifier/suppress-missed-schedule-notification"
+
+	suppressLongRunningAnnotationName = "kube-job-notifier/suppress-long-running-notification"
+	suppressStuckAnnotationName       = "kube-job-notifier/suppress-stuck-notification"
+	suppressRetryAnnotationName       = "kube-job-notifier/suppress-retry-notification"
 )
 
 var slackColors = map[string]string{
@@ -42,6 +65,7 @@ type slackClient interface {
 	UploadFileContext(ctx context.Context, params slackapi.UploadFileParameters) (file *slackapi.FileSummary, err error)
 	GetFileInfoContext(ctx context.Context, fileID string, count, page int) (*slackapi.File, []slackapi.Comment, *slackapi.Paging, error)
 	GetConversationsContext(ctx context.Context, params *slackapi.GetConversationsParameters) (channels []slackapi.Channel, nextCursor string, err error)
+	GetPermalinkContext(ctx context.Context, params *slackapi.PermalinkParameters) (string, error)
 }
 
 type slack struct {
@@ -49,11 +73,12 @@ type slack struct {
 	channel   string
 	channelID string
 	username  string
+	config    SlackConfig
 }
 
-func newSlack() (slack, error) {
+func newSlack(config SlackConfig) (slack, error) {
 	ctx := context.Background()
-	token := os.Getenv("SLACK_TOKEN")
+	token := config.Token
 	if token == "" {
 		return slack{}, fmt.Errorf("please set slack client")
 	}
@@ -62,8 +87,9 @@ func newSlack() (slack, error) {
 
 	client := slack{
 		client: newSlack,
+		config: config,
 	}
-	channel := os.Getenv("SLACK_CHANNEL")
+	channel := config.Channel
 
 	channelID := client.getChannelID(ctx, channel)
 	if channelID == "" {
@@ -71,7 +97,7 @@ func newSlack() (slack, error) {
 	}
 	client.channelID = channelID
 
-	username := os.Getenv("SLACK_USERNAME")
+	username := config.Username
 
 	client.username = username
 	client.channel = channel
@@ -79,18 +105,18 @@ func newSlack() (slack, error) {
 	return client, nil
 }
 
-func (s slack) NotifyStart(messageParam MessageTemplateParam) (err error) {
+func (s slack) NotifyStart(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
 
-	if !isNotifyFromEnv("SLACK_STARTED_NOTIFY") {
-		return nil
+	if !s.config.isNotify(notifyStarted) {
+		return nil, nil
 	}
 
 	if isNotificationSuppressed(messageParam.Annotations, suppressStartedAnnotationName) {
 		klog.Infof("Notification for %s is suppressed", messageParam.JobName)
-		return nil
+		return nil, nil
 	}
 
-	succeedChannel := os.Getenv("SLACK_SUCCEED_CHANNEL")
+	succeedChannel := s.config.SucceededChannel
 	if succeedChannel != "" {
 		s.channel = succeedChannel
 	}
@@ -99,10 +125,10 @@ func (s slack) NotifyStart(messageParam MessageTemplateParam) (err error) {
 		s.channel = slackChannel
 	}
 
-	slackMessage, err := getSlackMessage(messageParam)
+	slackMessage, err := s.getMessage(messageParam)
 	if err != nil {
 		klog.Errorf("Template execute failed %s\n", err)
-		return err
+		return nil, err
 	}
 
 	attachment := slackapi.Attachment{
@@ -111,38 +137,33 @@ func (s slack) NotifyStart(messageParam MessageTemplateParam) (err error) {
 		Text:  slackMessage,
 	}
 
-	err = s.notify(attachment)
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.notify(attachment)
 }
 
 func getSlackMessage(messageParam MessageTemplateParam) (slackMessage string, err error) {
-	var b bytes.Buffer
-	tpl, err := template.New("slack").Parse(SlackMessageTemplate)
-	if err != nil {
-		return "", err
-	}
-	err = tpl.Execute(&b, messageParam)
-	if err != nil {
-		return "", err
+	return executeTemplate("slack", SlackMessageTemplate, messageParam)
+}
+
+// getMessage renders the configured template, or SlackMessageTemplate.
+func (s slack) getMessage(messageParam MessageTemplateParam) (string, error) {
+	if s.config.Template == "" {
+		return getSlackMessage(messageParam)
 	}
-	return b.String(), nil
+	return executeTemplate("slack", s.config.Template, messageParam)
 }
 
-func (s slack) NotifySuccess(messageParam MessageTemplateParam) (err error) {
+func (s slack) NotifySuccess(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
 
-	if !isNotifyFromEnv("SLACK_SUCCEEDED_NOTIFY") {
-		return nil
+	if !s.config.isNotify(notifySucceeded) {
+		return nil, nil
 	}
 
 	if isNotificationSuppressed(messageParam.Annotations, suppressSuccessAnnotationName) {
 		klog.Infof("Notification for %s is suppressed", messageParam.JobName)
-		return nil
+		return nil, nil
 	}
 
-	succeedChannel := os.Getenv("SLACK_SUCCEED_CHANNEL")
+	succeedChannel := s.config.SucceededChannel
 	if succeedChannel != "" {
 		s.channel = succeedChannel
 	}
@@ -154,17 +175,17 @@ func (s slack) NotifySuccess(messageParam MessageTemplateParam) (err error) {
 		file, err := s.uploadLog(messageParam)
 		if err != nil {
 			klog.Errorf("Template execute failed %s\n", err)
-			return err
+			return nil, err
 		}
 		messageParam.Log = file.Permalink
 	}
 
 	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
 
-	slackMessage, err := getSlackMessage(messageParam)
+	slackMessage, err := s.getMessage(messageParam)
 	if err != nil {
 		klog.Errorf("Template execute failed %s\n", err)
-		return err
+		return nil, err
 	}
 	attachment := slackapi.Attachment{
 		Color: slackColors["Normal"],
@@ -172,25 +193,21 @@ func (s slack) NotifySuccess(messageParam MessageTemplateParam) (err error) {
 		Text:  slackMessage,
 	}
 
-	err = s.notify(attachment)
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.notify(attachment)
 }
 
-func (s slack) NotifyFailed(messageParam MessageTemplateParam) (err error) {
+func (s slack) NotifyFailed(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
 
-	if !isNotifyFromEnv("SLACK_FAILED_NOTIFY") {
-		return nil
+	if !s.config.isNotify(notifyFailed) {
+		return nil, nil
 	}
 
 	if isNotificationSuppressed(messageParam.Annotations, suppressFailedAnnotationName) {
 		klog.Infof("Notification for %s is suppressed", messageParam.JobName)
-		return nil
+		return nil, nil
 	}
 
-	failedChannel := os.Getenv("SLACK_FAILED_CHANNEL")
+	failedChannel := s.config.FailedChannel
 	if failedChannel != "" {
 		s.channel = failedChannel
 	}
@@ -202,17 +219,17 @@ func (s slack) NotifyFailed(messageParam MessageTemplateParam) (err error) {
 		file, err := s.uploadLog(messageParam)
 		if err != nil {
 			klog.Errorf("Template execute failed %s\n", err)
-			return err
+			return nil, err
 		}
 		messageParam.Log = file.Permalink
 	}
 
 	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
 
-	slackMessage, err := getSlackMessage(messageParam)
+	slackMessage, err := s.getMessage(messageParam)
 	if err != nil {
 		klog.Errorf("Template execute failed %s\n", err)
-		return err
+		return nil, err
 	}
 
 	attachment := slackapi.Attachment{
@@ -221,11 +238,66 @@ func (s slack) NotifyFailed(messageParam MessageTemplateParam) (err error) {
 		Text:  slackMessage,
 	}
 
-	err = s.notify(attachment)
+	return s.notify(attachment)
+}
+
+func (s slack) NotifyMissedSchedule(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
+	return s.notifyWarning(messageParam, notifyMissedSchedule,
+		suppressMissedAnnotationName, missedAnnotationName, "Missed Schedule")
+}
+
+func (s slack) NotifyStuck(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
+	return s.notifyWarning(messageParam, notifyStuck,
+		suppressStuckAnnotationName, stuckAnnotationName, "Job Stuck")
+}
+
+func (s slack) NotifyRetry(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
+	return s.notifyWarning(messageParam, notifyRetry,
+		suppressRetryAnnotationName, retryAnnotationName, messageParam.getRetryTitle())
+}
+
+func (s slack) NotifyLongRunning(messageParam MessageTemplateParam) (delivery *Delivery, err error) {
+	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
+	messageParam.CompletionTime = nil
+	return s.notifyWarning(messageParam, notifyLongRunning,
+		suppressLongRunningAnnotationName, longRunningAnnotationName, "Job Running Longer Than Expected")
+}
+
+// notifyWarning sends a warning to the failed channel unless it is overridden
+// by the channel annotation.
+func (s slack) notifyWarning(messageParam MessageTemplateParam, notifyKey, suppressAnnotationName, channelAnnotationName, title string) (delivery *Delivery, err error) {
+
+	if !s.config.isNotify(notifyKey) {
+		return nil, nil
+	}
+
+	if isNotificationSuppressed(messageParam.Annotations, suppressAnnotationName) {
+		klog.Infof("Notification for %s is suppressed", messageParam.getName())
+		return nil, nil
+	}
+
+	failedChannel := s.config.FailedChannel
+	if failedChannel != "" {
+		s.channel = failedChannel
+	}
+	slackChannel := getSlackChannel(messageParam.Annotations, channelAnnotationName)
+	if slackChannel != "" {
+		s.channel = slackChannel
+	}
+
+	slackMessage, err := s.getMessage(messageParam)
 	if err != nil {
-		return err
+		klog.Errorf("Template execute failed %s\n", err)
+		return nil, err
 	}
-	return nil
+
+	attachment := slackapi.Attachment{
+		Color: slackColors["Warning"],
+		Title: title,
+		Text:  slackMessage,
+	}
+
+	return s.notify(attachment)
 }
 
 func getSlackChannel(annotations map[string]string, annotationName string) string {
@@ -236,6 +308,22 @@ func getSlackChannel(annotations map[string]string, annotationName string) strin
 	return slackChannel
 }
 
+// WithSlackChannel returns a copy of the message that is sent to the channel
+// for every event, overriding the configured and annotated channels.
+func (m MessageTemplateParam) WithSlackChannel(channel string) MessageTemplateParam {
+	annotations := make(map[string]string, len(m.Annotations)+1)
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+	for _, name := range []string{successAnnotationName, startedAnnotationName, failedAnnotationName,
+		missedAnnotationName, longRunningAnnotationName, stuckAnnotationName, retryAnnotationName} {
+		delete(annotations, name)
+	}
+	annotations[defaultAnnotationName] = channel
+	m.Annotations = annotations
+	return m
+}
+
 func isNotificationSuppressed(annotations map[string]string, annotationName string) bool {
 	a, ok := annotations[annotationName]
 	if !ok {
@@ -244,7 +332,7 @@ func isNotificationSuppressed(annotations map[string]string, annotationName stri
 	return a == "true"
 }
 
-func (s slack) notify(attachment slackapi.Attachment) (err error) {
+func (s slack) notify(attachment slackapi.Attachment) (delivery *Delivery, err error) {
 
 	channelID, timestamp, err := s.client.PostMessage(
 		s.channel,
@@ -255,16 +343,23 @@ func (s slack) notify(attachment slackapi.Attachment) (err error) {
 
 	if err != nil {
 		klog.Errorf("Send messageParam failed %s\n", err)
-		return
+		return nil, err
 	}
 
 	klog.Infof("Message successfully sent to channel %s at %s", channelID, timestamp)
-	return err
+
+	// The message was delivered, so a missing permalink is not an error.
+	permalink, err := s.client.GetPermalinkContext(context.Background(),
+		&slackapi.PermalinkParameters{Channel: channelID, Ts: timestamp})
+	if err != nil {
+		klog.Warningf("Get permalink failed %s\n", err)
+	}
+	return &Delivery{Channel: channelID, Timestamp: timestamp, Permalink: permalink}, nil
 }
 
 func (s slack) uploadLog(param MessageTemplateParam) (file *slackapi.File, err error) {
 	ctx := context.Background()
-	content := param.Log
+	content := param.getLogFileContent()
 	filename := param.Namespace + "_" + param.JobName + ".txt"
 
 	fileSize := len([]byte(content))
@@ -314,11 +409,6 @@ func (s slack) uploadLog(param MessageTemplateParam) (file *slackapi.File, err e
 	return fileInfo, nil
 }
 
-func isNotifyFromEnv(key string) bool {
-	value := os.Getenv(key)
-	return value != "false"
-}
-
 // getChannelID converts a channel name (e.g., "#channel-name") to a channel ID (e.g., "C1234567890")
 // If the input is already a channel ID or lookup fails, it returns the original value
 func (s slack) getChannelID(ctx context.Context, channel string) string {
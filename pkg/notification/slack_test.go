@@ -3,6 +3,7 @@ package notification
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ func TestNewSlack(t *testing.T) {
 		channel:  "C12345678",
 		username: "slack_username",
 	}
-	actual, err := newSlack()
+	actual, err := newSlack(configFromEnv().Slack)
 	assert.NoError(t, err)
 	assert.Equal(t, expected.channel, actual.channel)
 	assert.Equal(t, expected.username, actual.username)
@@ -31,7 +32,7 @@ func TestNewSlack(t *testing.T) {
 	os.Unsetenv("SLACK_CHANNEL")
 	os.Unsetenv("SLACK_USERNAME")
 
-	actual, err = newSlack()
+	actual, err = newSlack(configFromEnv().Slack)
 	assert.NoError(t, err)
 	expected = slack{
 		client:   slackapi.New("slack_token"),
@@ -43,7 +44,7 @@ func TestNewSlack(t *testing.T) {
 
 	// Test error when token is not set
 	os.Unsetenv("SLACK_TOKEN")
-	_, err = newSlack()
+	_, err = newSlack(configFromEnv().Slack)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "please set slack client")
 }
@@ -121,16 +122,27 @@ func TestNotifyStart(t *testing.T) {
 			if test.notifyCalled {
 				mc.On("PostMessage", test.expectedChannel, mock.AnythingOfType("[]slack.MsgOption")).
 					Return(test.expectedChannel, "timestamp", nil)
+				mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: test.expectedChannel, Ts: "timestamp"}).
+					Return("https://example.slack.com/archives/C123/p1", nil)
 			}
 
-			slack := slack{client: mc, channel: defaultChannel, username: u}
+			slack := slack{client: mc, channel: defaultChannel, username: u, config: configFromEnv().Slack}
 
-			err := slack.NotifyStart(MessageTemplateParam{
+			delivery, err := slack.NotifyStart(MessageTemplateParam{
 				JobName:     "the-job",
 				Annotations: test.annotations,
 			})
 
 			assert.NoError(t, err)
+			if test.notifyCalled {
+				assert.Equal(t, &Delivery{
+					Channel:   test.expectedChannel,
+					Timestamp: "timestamp",
+					Permalink: "https://example.slack.com/archives/C123/p1",
+				}, delivery)
+			} else {
+				assert.Nil(t, delivery)
+			}
 			mc.AssertExpectations(t)
 
 			os.Unsetenv("SLACK_STARTED_NOTIFY")
@@ -212,11 +224,13 @@ func TestNotifySuccess(t *testing.T) {
 			if test.notifyCalled {
 				mc.On("PostMessage", test.expectedChannel, mock.AnythingOfType("[]slack.MsgOption")).
 					Return(test.expectedChannel, "timestamp", nil)
+				mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: test.expectedChannel, Ts: "timestamp"}).
+					Return("https://example.slack.com/archives/C123/p1", nil)
 			}
 
-			slack := slack{client: mc, channel: defaultChannel, username: u}
+			slack := slack{client: mc, channel: defaultChannel, username: u, config: configFromEnv().Slack}
 
-			err := slack.NotifySuccess(MessageTemplateParam{
+			_, err := slack.NotifySuccess(MessageTemplateParam{
 				JobName:     "the-job",
 				Annotations: test.annotations,
 			})
@@ -303,11 +317,13 @@ func TestNotifyFailed(t *testing.T) {
 			if test.notifyCalled {
 				mc.On("PostMessage", test.expectedChannel, mock.AnythingOfType("[]slack.MsgOption")).
 					Return(test.expectedChannel, "timestamp", nil)
+				mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: test.expectedChannel, Ts: "timestamp"}).
+					Return("https://example.slack.com/archives/C123/p1", nil)
 			}
 
-			slack := slack{client: mc, channel: defaultChannel, username: u}
+			slack := slack{client: mc, channel: defaultChannel, username: u, config: configFromEnv().Slack}
 
-			err := slack.NotifyFailed(MessageTemplateParam{
+			_, err := slack.NotifyFailed(MessageTemplateParam{
 				JobName:     "the-job",
 				Annotations: test.annotations,
 			})
@@ -321,6 +337,167 @@ func TestNotifyFailed(t *testing.T) {
 	}
 }
 
+func TestNotifyMissedSchedule(t *testing.T) {
+	defaultChannel := "default_channel"
+	tests := []struct {
+		Name             string
+		notifyEnv        string
+		failedChannelEnv string
+		annotations      map[string]string
+
+		expectedChannel string
+		notifyCalled    bool
+	}{
+		{
+			"Notify turned off",
+			"false",
+			"",
+			map[string]string{},
+
+			defaultChannel,
+			false,
+		},
+		{
+			"Notify suppressed in annotations",
+			"true",
+			"",
+			map[string]string{
+				"kube-job-notifier/suppress-missed-schedule-notification": "true",
+			},
+
+			defaultChannel,
+			false,
+		},
+		{
+			"Failed channel from environment",
+			"true",
+			"failed-channel",
+			map[string]string{},
+
+			"failed-channel",
+			true,
+		},
+		{
+			"Missed schedule channel overwritten in annotations",
+			"true",
+			"failed-channel",
+			map[string]string{
+				"kube-job-notifier/missed-schedule-channel": "from-annotations",
+			},
+
+			"from-annotations",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			os.Setenv("SLACK_MISSED_SCHEDULE_NOTIFY", test.notifyEnv)
+			os.Setenv("SLACK_FAILED_CHANNEL", test.failedChannelEnv)
+
+			mc := &MockSlackClient{}
+			if test.notifyCalled {
+				mc.On("PostMessage", test.expectedChannel, mock.AnythingOfType("[]slack.MsgOption")).
+					Return(test.expectedChannel, "timestamp", nil)
+				mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: test.expectedChannel, Ts: "timestamp"}).
+					Return("https://example.slack.com/archives/C123/p1", nil)
+			}
+
+			slack := slack{client: mc, channel: defaultChannel, username: "job_notifier", config: configFromEnv().Slack}
+
+			_, err := slack.NotifyMissedSchedule(MessageTemplateParam{
+				CronJobName: "the-cronjob",
+				Annotations: test.annotations,
+			})
+
+			assert.NoError(t, err)
+			mc.AssertExpectations(t)
+
+			os.Unsetenv("SLACK_MISSED_SCHEDULE_NOTIFY")
+			os.Unsetenv("SLACK_FAILED_CHANNEL")
+		})
+	}
+}
+
+func TestNotifyLongRunning(t *testing.T) {
+	t.Run("sends to long-running channel from annotations", func(t *testing.T) {
+		mc := &MockSlackClient{}
+		mc.On("PostMessage", "from-annotations", mock.AnythingOfType("[]slack.MsgOption")).
+			Return("from-annotations", "timestamp", nil)
+		mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: "from-annotations", Ts: "timestamp"}).
+			Return("https://example.slack.com/archives/C123/p1", nil)
+
+		slack := slack{client: mc, channel: "default_channel", username: "job_notifier", config: configFromEnv().Slack}
+
+		_, err := slack.NotifyLongRunning(MessageTemplateParam{
+			JobName: "the-job",
+			Annotations: map[string]string{
+				"kube-job-notifier/long-running-channel": "from-annotations",
+			},
+		})
+
+		assert.NoError(t, err)
+		mc.AssertExpectations(t)
+	})
+
+	t.Run("suppressed in annotations", func(t *testing.T) {
+		mc := &MockSlackClient{}
+		slack := slack{client: mc, channel: "default_channel", username: "job_notifier", config: configFromEnv().Slack}
+
+		_, err := slack.NotifyLongRunning(MessageTemplateParam{
+			JobName: "the-job",
+			Annotations: map[string]string{
+				"kube-job-notifier/suppress-long-running-notification": "true",
+			},
+		})
+
+		assert.NoError(t, err)
+		mc.AssertExpectations(t)
+	})
+}
+
+func TestNotifyStuck(t *testing.T) {
+	os.Setenv("SLACK_FAILED_CHANNEL", "failed-channel")
+	defer os.Unsetenv("SLACK_FAILED_CHANNEL")
+
+	mc := &MockSlackClient{}
+	mc.On("PostMessage", "failed-channel", mock.AnythingOfType("[]slack.MsgOption")).
+		Return("failed-channel", "timestamp", nil)
+	mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: "failed-channel", Ts: "timestamp"}).
+		Return("https://example.slack.com/archives/C123/p1", nil)
+
+	slack := slack{client: mc, channel: "default_channel", username: "job_notifier", config: configFromEnv().Slack}
+
+	_, err := slack.NotifyStuck(MessageTemplateParam{
+		JobName:     "the-job",
+		StuckReason: "ImagePullBackOff",
+	})
+
+	assert.NoError(t, err)
+	mc.AssertExpectations(t)
+}
+
+func TestNotifyRetry(t *testing.T) {
+	mc := &MockSlackClient{}
+	mc.On("PostMessage", "retry-channel", mock.AnythingOfType("[]slack.MsgOption")).
+		Return("retry-channel", "timestamp", nil)
+	mc.On("GetPermalinkContext", mock.Anything, &slackapi.PermalinkParameters{Channel: "retry-channel", Ts: "timestamp"}).
+		Return("https://example.slack.com/archives/C123/p1", nil)
+
+	slack := slack{client: mc, channel: "default_channel", username: "job_notifier", config: configFromEnv().Slack}
+
+	_, err := slack.NotifyRetry(MessageTemplateParam{
+		JobName:           "the-job",
+		Annotations:       map[string]string{retryAnnotationName: "retry-channel"},
+		Attempt:           2,
+		MaxAttempts:       6,
+		ContainerFailures: []ContainerFailure{{Name: "app", Reason: "Error", ExitCode: 1}},
+	})
+
+	assert.NoError(t, err)
+	mc.AssertExpectations(t)
+}
+
 type MockSlackClient struct {
 	mock.Mock
 }
@@ -346,6 +523,11 @@ func (c *MockSlackClient) GetFileInfoContext(ctx context.Context, fileID string,
 	return args.Get(0).(*slackapi.File), args.Get(1).([]slackapi.Comment), args.Get(2).(*slackapi.Paging), args.Error(3)
 }
 
+func (c *MockSlackClient) GetPermalinkContext(ctx context.Context, params *slackapi.PermalinkParameters) (string, error) {
+	args := c.Called(ctx, params)
+	return args.String(0), args.Error(1)
+}
+
 func (c *MockSlackClient) GetConversationsContext(ctx context.Context, params *slackapi.GetConversationsParameters) (channels []slackapi.Channel, nextCursor string, err error) {
 	args := c.Called(ctx, params)
 	if args.Get(0) == nil {
@@ -416,6 +598,98 @@ func TestGetSlackMessage(t *testing.T) {
 	assert.Equal(t, expect, actual)
 }
 
+func TestGetSlackMessageWithCronJobSchedule(t *testing.T) {
+	lastSuccessfulTime := &metav1.Time{Time: time.Date(2020, 11, 28, 1, 2, 3, 0, time.UTC)}
+	input := MessageTemplateParam{
+		JobName:            "Job",
+		CronJobName:        "CronJob",
+		CronJobSchedule:    "*/5 * * * *",
+		CronJobTimeZone:    "Asia/Tokyo",
+		LastSuccessfulTime: lastSuccessfulTime,
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.Contains(t, actual, " *Schedule*: */5 * * * * (Asia/Tokyo)\n")
+	assert.Contains(t, actual, " *LastSuccessfulTime*: 2020/11/28 01:02:03 UTC\n")
+}
+
+func TestGetSlackMessageWithFailureReason(t *testing.T) {
+	input := MessageTemplateParam{
+		JobName:        "Job",
+		FailureReason:  "BackoffLimitExceeded",
+		FailureMessage: "Job has reached the specified backoff limit",
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.Contains(t, actual, "\n *Reason*: BackoffLimitExceeded\n *Message*: Job has reached the specified backoff limit")
+}
+
+func TestGetSlackMessageWithGrouped(t *testing.T) {
+	input := MessageTemplateParam{
+		JobName: "nightly-3",
+		Grouped: []GroupedEvent{
+			{Event: "failed", Namespace: "billing", CronJobName: "nightly", JobName: "nightly-2"},
+			{Event: "missed-schedule", Namespace: "billing", CronJobName: "hourly"},
+		},
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.Contains(t, actual, "\n *Grouped*: failed billing/nightly-2\n *Grouped*: missed-schedule billing/hourly\n")
+}
+
+func TestGetSlackMessageWithContainerFailures(t *testing.T) {
+	input := MessageTemplateParam{
+		JobName:       "Job",
+		FailureReason: "BackoffLimitExceeded",
+		ContainerFailures: []ContainerFailure{
+			{Name: "app", Reason: "OOMKilled", ExitCode: 137},
+			{Name: "sidecar", ExitCode: 143, Signal: 15, Message: "shutting down"},
+		},
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.Contains(t, actual, "\n *Reason*: BackoffLimitExceeded"+
+		"\n *Container*: app OOMKilled (exit 137)"+
+		"\n *Container*: sidecar (exit 143, signal 15)"+
+		"\n *TerminationMessage*: shutting down\n")
+}
+
+func TestGetSlackMessageWithEvents(t *testing.T) {
+	input := MessageTemplateParam{
+		JobName: "Job",
+		Events: []JobEvent{
+			{Type: "Warning", Reason: "FailedMount", Object: "Pod/job-x2k4f", Message: `secret "db" not found`, Count: 4},
+			{Type: "Warning", Reason: "BackoffLimitExceeded", Object: "Job/job", Message: "Job has reached the specified backoff limit", Count: 1},
+		},
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.Contains(t, actual, "\n *Event*: Warning FailedMount Pod/job-x2k4f (x4): secret &#34;db&#34; not found"+
+		"\n *Event*: Warning BackoffLimitExceeded Job/job: Job has reached the specified backoff limit\n")
+}
+
+func TestGetSlackMessageWithClusterName(t *testing.T) {
+	input := MessageTemplateParam{
+		ClusterName: "prod-eu",
+		JobName:     "Job",
+	}
+
+	actual, err := getSlackMessage(input)
+
+	assert.Empty(t, err)
+	assert.True(t, strings.HasPrefix(actual, "\n *ClusterName*: prod-eu\n\n *JobName*: Job\n"), actual)
+}
+
 func TestGetSlackChannel(t *testing.T) {
 	tests := []struct {
 		Name              string
@@ -465,6 +739,18 @@ func TestGetSlackChannel(t *testing.T) {
 	}
 }
 
+func TestWithSlackChannel(t *testing.T) {
+	annotations := map[string]string{
+		"kube-job-notifier/default-channel": "job-alerts",
+		"kube-job-notifier/failed-channel":  "job-alerts-failed",
+	}
+	param := MessageTemplateParam{JobName: "job", Annotations: annotations}.WithSlackChannel("team-alerts")
+
+	assert.Equal(t, "team-alerts", getSlackChannel(param.Annotations, failedAnnotationName))
+	assert.Equal(t, "team-alerts", getSlackChannel(param.Annotations, successAnnotationName))
+	assert.Equal(t, "job-alerts-failed", annotations[failedAnnotationName], "the original annotations are unchanged")
+}
+
 func TestIsNotificationSuppressed(t *testing.T) {
 	tests := []struct {
 		Name                   string
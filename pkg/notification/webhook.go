@@ -0,0 +1,118 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// webhookTimeout bounds each request, so that an unresponsive webhook does
+// not block the worker sending it.
+const webhookTimeout = 10 * time.Second
+
+// Events of WebhookPayload.
+const (
+	webhookEventStart          = "start"
+	webhookEventSuccess        = "success"
+	webhookEventFailed         = "failed"
+	webhookEventMissedSchedule = "missed-schedule"
+	webhookEventLongRunning    = "long-running"
+	webhookEventStuck          = "stuck"
+	webhookEventRetry          = "retry"
+)
+
+// WebhookPayload is the JSON body posted to webhooks.
+type WebhookPayload struct {
+	// Event is one of start, success, failed, missed-schedule, long-running,
+	// stuck and retry.
+	Event string               `json:"event"`
+	Title string               `json:"title"`
+	Job   MessageTemplateParam `json:"job"`
+}
+
+type webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhook(config WebhookConfig) (webhook, error) {
+	if config.URL == "" {
+		return webhook{}, fmt.Errorf("please set webhook URL")
+	}
+	return webhook{
+		url:     config.URL,
+		headers: config.Headers,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+// NotifyStart implements Notification.
+func (w webhook) NotifyStart(messageParam MessageTemplateParam) (*Delivery, error) {
+	return w.send(webhookEventStart, "Job Start", messageParam)
+}
+
+// NotifySuccess implements Notification.
+func (w webhook) NotifySuccess(messageParam MessageTemplateParam) (*Delivery, error) {
+	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
+	return w.send(webhookEventSuccess, "Job Succeeded", messageParam)
+}
+
+// NotifyFailed implements Notification.
+func (w webhook) NotifyFailed(messageParam MessageTemplateParam) (*Delivery, error) {
+	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
+	return w.send(webhookEventFailed, "Job Failed", messageParam)
+}
+
+// NotifyMissedSchedule implements Notification.
+func (w webhook) NotifyMissedSchedule(messageParam MessageTemplateParam) (*Delivery, error) {
+	return w.send(webhookEventMissedSchedule, "Missed Schedule", messageParam)
+}
+
+// NotifyLongRunning implements Notification.
+func (w webhook) NotifyLongRunning(messageParam MessageTemplateParam) (*Delivery, error) {
+	messageParam.CompletionTime, messageParam.ExecutionTime = messageParam.calculateExecutionTime()
+	messageParam.CompletionTime = nil
+	return w.send(webhookEventLongRunning, "Job Running Longer Than Expected", messageParam)
+}
+
+// NotifyStuck implements Notification.
+func (w webhook) NotifyStuck(messageParam MessageTemplateParam) (*Delivery, error) {
+	return w.send(webhookEventStuck, "Job Stuck", messageParam)
+}
+
+// NotifyRetry implements Notification.
+func (w webhook) NotifyRetry(messageParam MessageTemplateParam) (*Delivery, error) {
+	return w.send(webhookEventRetry, messageParam.getRetryTitle(), messageParam)
+}
+
+// send posts the payload. The Delivery is empty, as webhooks do not identify
+// the posted message.
+func (w webhook) send(event, title string, messageParam MessageTemplateParam) (*Delivery, error) {
+	body, err := json.Marshal(WebhookPayload{Event: event, Title: title, Job: messageParam})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	klog.V(4).Infof("Webhook response status for %s: %s", messageParam.getName(), resp.Status)
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook returned HTTP status %d", resp.StatusCode)
+	}
+	return &Delivery{}, nil
+}
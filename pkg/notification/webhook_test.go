@@ -0,0 +1,71 @@
+package notification
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewWebhook(t *testing.T) {
+	_, err := newWebhook(WebhookConfig{})
+	assert.Error(t, err)
+
+	w, err := newWebhook(WebhookConfig{URL: "https://example.com/hook"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", w.url)
+}
+
+func TestWebhook_NotifyFailed(t *testing.T) {
+	mockTime := time.Date(2020, 11, 28, 1, 2, 3, 0, time.UTC)
+	restore := flextime.Set(mockTime)
+	defer restore()
+
+	var received WebhookPayload
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	w, err := newWebhook(WebhookConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	assert.NoError(t, err)
+	delivery, err := w.NotifyFailed(MessageTemplateParam{
+		JobName:       "test-job",
+		Namespace:     "default",
+		StartTime:     &metav1.Time{Time: mockTime.Add(-time.Minute)},
+		FailureReason: "BackoffLimitExceeded",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Delivery{}, delivery)
+	assert.Equal(t, "Bearer token", authorization)
+	assert.Equal(t, webhookEventFailed, received.Event)
+	assert.Equal(t, "Job Failed", received.Title)
+	assert.Equal(t, "test-job", received.Job.JobName)
+	assert.Equal(t, "BackoffLimitExceeded", received.Job.FailureReason)
+	assert.Equal(t, time.Minute, received.Job.ExecutionTime)
+}
+
+func TestWebhook_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	w, err := newWebhook(WebhookConfig{URL: server.URL})
+	assert.NoError(t, err)
+	delivery, err := w.NotifyStart(MessageTemplateParam{JobName: "test-job"})
+
+	assert.Nil(t, delivery)
+	assert.ErrorContains(t, err, "502")
+}
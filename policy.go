@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+const (
+	// policyConditionReady is the condition reporting whether a
+	// NotificationPolicy is valid and used to route notifications.
+	policyConditionReady = "Ready"
+	policyReasonValid    = "Valid"
+	policyReasonInvalid  = "Invalid"
+
+	// policySecretLabelName opts a Secret in to being referenced by the Teams
+	// destinations of NotificationPolicies. Only Secrets labeled "true" are
+	// cached and read.
+	policySecretLabelName = "kube-job-notifier.io/notification-policy-secret"
+)
+
+// policyGVR is the resource of NotificationPolicies, see
+// charts/kube-job-notifier/crds/notificationpolicies.yaml.
+var policyGVR = schema.GroupVersionResource{
+	Group:    "kube-job-notifier.io",
+	Version:  "v1alpha1",
+	Resource: "notificationpolicies",
+}
+
+// policyEventTypes maps the event types of NotificationPolicies to events.
+var policyEventTypes = map[string]string{
+	"start":       eventStart,
+	"success":     eventSuccess,
+	"failure":     eventFailed,
+	"stuck":       eventStuck,
+	"missed":      eventMissedSchedule,
+	"longRunning": eventLongRunning,
+	"retry":       eventRetry,
+}
+
+// notificationPolicy routes the notifications of the Jobs it selects in its
+// namespace, so that teams can change where their Jobs are notified without
+// changing the config of the notifier. The Jobs selected by at least one
+// policy are only notified to the destinations of their policies.
+type notificationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   notificationPolicySpec   `json:"spec"`
+	Status notificationPolicyStatus `json:"status,omitempty"`
+}
+
+type notificationPolicySpec struct {
+	// Selector selects Jobs by label, and CronJobs by the labels of their
+	// Job template. A nil selector selects every Job of the namespace.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Events are the event types notified, see policyEventTypes. Every event
+	// is notified if empty.
+	Events       []string            `json:"events,omitempty"`
+	Destinations []policyDestination `json:"destinations"`
+	// SuppressionWindows are the times in which nothing is notified, e.g.
+	// during a maintenance.
+	SuppressionWindows []suppressionWindow `json:"suppressionWindows,omitempty"`
+}
+
+// policyDestination is exactly one of a Slack channel, a Teams webhook or
+// Datadog.
+type policyDestination struct {
+	Slack     *slackDestination     `json:"slack,omitempty"`
+	MsTeamsV2 *msTeamsV2Destination `json:"msteamsv2,omitempty"`
+	Datadog   *datadogDestination   `json:"datadog,omitempty"`
+}
+
+// slackDestination is a channel posted to with the configured Slack token.
+type slackDestination struct {
+	Channel string `json:"channel"`
+}
+
+// msTeamsV2Destination is a Teams webhook whose URL is read from a Secret in
+// the namespace of the policy.
+type msTeamsV2Destination struct {
+	WebhookURLSecretRef corev1.SecretKeySelector `json:"webhookURLSecretRef"`
+}
+
+// datadogDestination sends service checks with the configured Datadog client
+// and additional tags.
+type datadogDestination struct {
+	Tags []string `json:"tags,omitempty"`
+}
+
+// suppressionWindow is a daily time range, e.g. from "22:00" to "06:00". A
+// window ending before it starts ends on the next day. Days restricts it to
+// the weekdays it starts on, e.g. ["Saturday", "Sunday"].
+type suppressionWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+	// TimeZone is an IANA time zone name, UTC if empty.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+type notificationPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// validate checks the fields the CRD schema cannot check.
+func (p *notificationPolicy) validate() error {
+	var errs []string
+	if _, err := metav1.LabelSelectorAsSelector(p.Spec.Selector); err != nil {
+		errs = append(errs, fmt.Sprintf("selector: %v", err))
+	}
+	for _, event := range p.Spec.Events {
+		if _, ok := policyEventTypes[event]; !ok {
+			errs = append(errs, fmt.Sprintf("events: unknown event %q", event))
+		}
+	}
+	if len(p.Spec.Destinations) == 0 {
+		errs = append(errs, "destinations: at least one destination is required")
+	}
+	for i, d := range p.Spec.Destinations {
+		var sinks int
+		if d.Slack != nil {
+			sinks++
+			if d.Slack.Channel == "" {
+				errs = append(errs, fmt.Sprintf("destinations[%d].slack.channel is required", i))
+			}
+		}
+		if d.MsTeamsV2 != nil {
+			sinks++
+			ref := d.MsTeamsV2.WebhookURLSecretRef
+			if ref.Name == "" || ref.Key == "" {
+				errs = append(errs, fmt.Sprintf("destinations[%d].msteamsv2.webhookURLSecretRef: name and key are required", i))
+			}
+		}
+		if d.Datadog != nil {
+			sinks++
+		}
+		if sinks != 1 {
+			errs = append(errs, fmt.Sprintf("destinations[%d]: exactly one of slack, msteamsv2 and datadog is required", i))
+		}
+	}
+	for i, w := range p.Spec.SuppressionWindows {
+		if err := w.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("suppressionWindows[%d]: %v", i, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// selects reports whether the policy selects Jobs with the labels.
+func (p *notificationPolicy) selects(jobLabels map[string]string) bool {
+	if p.Spec.Selector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.Selector)
+	return err == nil && selector.Matches(labels.Set(jobLabels))
+}
+
+// notifies reports whether the policy notifies the event.
+func (p *notificationPolicy) notifies(event string) bool {
+	return matchesEventTypes(p.Spec.Events, event)
+}
+
+// matchesEventTypes reports whether the event is one of the event types, see
+// policyEventTypes. Every event matches an empty list.
+func matchesEventTypes(eventTypes []string, event string) bool {
+	if len(eventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range eventTypes {
+		e := policyEventTypes[eventType]
+		if event == e || (e == eventRetry && strings.HasPrefix(event, eventRetry+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSuppressed reports whether t is in one of the suppression windows.
+func (p *notificationPolicy) isSuppressed(t time.Time) bool {
+	for _, w := range p.Spec.SuppressionWindows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w suppressionWindow) validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	for _, day := range w.Days {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("days: unknown day %q", day)
+		}
+	}
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("timeZone: %w", err)
+	}
+	return nil
+}
+
+// contains reports whether t is in the window. The window must be valid.
+func (w suppressionWindow) contains(t time.Time) bool {
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	startDay := t.Weekday()
+	switch {
+	case start <= end:
+		if now < start || now >= end {
+			return false
+		}
+	case now >= start:
+	case now < end:
+		// The window started on the previous day.
+		startDay = (startDay + 6) % 7
+	default:
+		return false
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if d, _ := parseWeekday(day); d == startDay {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses a time of day such as "22:30" to the duration since
+// midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekday parses a day such as "Saturday" or "Sat", ignoring case.
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// toNotificationPolicy converts an object of the policy informer.
+func toNotificationPolicy(obj runtime.Object) (*notificationPolicy, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	policy := &notificationPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// enablePolicies makes the controller route notifications by the
+// NotificationPolicies of the informer and report their validity in their
+// status. It must be called before the informer is started.
+func (c *Controller) enablePolicies(client dynamic.Interface, policyInformer informers.GenericInformer,
+	secretInformer coreinformers.SecretInformer) {
+	c.policyClient = client.Resource(policyGVR)
+	c.policyLister = policyInformer.Lister()
+	c.policiesSynced = policyInformer.Informer().HasSynced
+	c.secretLister = secretInformer.Lister()
+	c.secretsSynced = secretInformer.Informer().HasSynced
+	c.informers["secrets"] = secretInformer.Informer()
+	c.informers["notificationpolicies"] = policyInformer.Informer()
+	c.policyQueue = workqueue.NewTypedRateLimitingQueueWithConfig(
+		newRateLimiter(),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: c.config.queueName("NotificationPolicies")},
+	)
+	policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueuePolicy,
+		UpdateFunc: func(old, new any) {
+			c.enqueuePolicy(new)
+		},
+	})
+}
+
+func (c *Controller) enqueuePolicy(obj any) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.policyQueue.Add(key)
+}
+
+func (c *Controller) runPolicyWorker() {
+	for c.processNextPolicy() {
+	}
+}
+
+func (c *Controller) processNextPolicy() bool {
+	key, shutdown := c.policyQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.policyQueue.Done(key)
+
+	err := c.syncPolicy(key)
+	if err == nil {
+		c.policyQueue.Forget(key)
+		return true
+	}
+
+	if c.policyQueue.NumRequeues(key) < maxRetries {
+		klog.Errorf("Error syncing notification policy %s, requeuing: %v", key, err)
+		c.policyQueue.AddRateLimited(key)
+		return true
+	}
+
+	c.policyQueue.Forget(key)
+	utilruntime.HandleError(fmt.Errorf("dropping notification policy %s out of the queue after %d retries: %v", key, maxRetries, err))
+	return true
+}
+
+// syncPolicy validates the NotificationPolicy and updates its Ready
+// condition when it changed.
+func (c *Controller) syncPolicy(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+	obj, err := c.policyLister.ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:    policyConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  policyReasonValid,
+		Message: "The policy routes the notifications of the Jobs it selects",
+	}
+	policy, err := toNotificationPolicy(obj)
+	if err == nil {
+		err = policy.validate()
+	} else {
+		policy = &notificationPolicy{}
+	}
+	if err != nil {
+		klog.Warningf("Notification policy %s is invalid: %v", key, err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = policyReasonInvalid
+		condition.Message = err.Error()
+	}
+
+	u := obj.(*unstructured.Unstructured)
+	condition.ObservedGeneration = u.GetGeneration()
+	changed := meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	if !changed && policy.Status.ObservedGeneration == u.GetGeneration() {
+		return nil
+	}
+	policy.Status.ObservedGeneration = u.GetGeneration()
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policy.Status)
+	if err != nil {
+		return err
+	}
+	u = u.DeepCopy()
+	u.Object["status"] = status
+	_, err = c.policyClient.Namespace(namespace).UpdateStatus(context.TODO(), u, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// getJobLabels returns the namespace and the Job labels of obj, a Job or a
+// CronJob, whose Jobs get the labels of its Job template.
+func getJobLabels(obj runtime.Object) (namespace string, jobLabels map[string]string) {
+	switch o := obj.(type) {
+	case *batchv1.Job:
+		return o.Namespace, o.Labels
+	case *batchv1.CronJob:
+		return o.Namespace, o.Spec.JobTemplate.Labels
+	}
+	return "", nil
+}
+
+// getPolicies returns the valid NotificationPolicies selecting obj, a Job or
+// a CronJob, or nil if policies are not enabled.
+func (c *Controller) getPolicies(obj runtime.Object) []*notificationPolicy {
+	if c.policyLister == nil {
+		return nil
+	}
+	namespace, jobLabels := getJobLabels(obj)
+	if namespace == "" {
+		return nil
+	}
+	objs, err := c.policyLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List notification policies in %s failed: %v", namespace, err)
+		return nil
+	}
+	var policies []*notificationPolicy
+	for _, obj := range objs {
+		policy, err := toNotificationPolicy(obj)
+		if err == nil {
+			err = policy.validate()
+		}
+		if err != nil {
+			klog.V(4).Infof("Skipping invalid notification policy in %s: %v", namespace, err)
+			continue
+		}
+		if policy.selects(jobLabels) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// getTargets returns the targets of the event about obj. Without a
+// NotificationPolicy selecting obj they are the configured sinks, see
+// getDefaultTargets. Otherwise
+// they are the destinations of the policies that notify the event and are
+// not in a suppression window.
+func (c *Controller) getTargets(obj runtime.Object, event string, messageParam notification.MessageTemplateParam,
+	jobInfo monitoring.JobInfo) []target {
+
+	policies := c.getPolicies(obj)
+	if len(policies) == 0 {
+		return c.getDefaultTargets(obj, event, messageParam, jobInfo)
+	}
+
+	notifications, datadogSubscription := c.getSinks()
+	now := time.Now()
+	var targets []target
+	for _, policy := range policies {
+		if !policy.notifies(event) {
+			continue
+		}
+		if policy.isSuppressed(now) {
+			klog.Infof("Notification of %s for %s is suppressed by policy %s/%s",
+				event, messageParam.JobName, policy.Namespace, policy.Name)
+			continue
+		}
+		for i, d := range policy.Spec.Destinations {
+			t := target{messageParam: messageParam, jobInfo: jobInfo}
+			switch {
+			case d.Slack != nil:
+				t.sink = "slack"
+				t.notification = notifications[t.sink]
+				if t.notification == nil {
+					t.err = fmt.Errorf("slack is not configured")
+				}
+				t.messageParam = messageParam.WithSlackChannel(d.Slack.Channel)
+			case d.MsTeamsV2 != nil:
+				t.sink = "msteamsv2"
+				t.notification, t.err = c.newPolicyMsTeamsV2(policy.Namespace, d.MsTeamsV2.WebhookURLSecretRef)
+				t.messageParam = messageParam.WithoutMsTeamsV2WebhookURL()
+			case d.Datadog != nil:
+				t.sink = datadogSinkName
+				t.subscription = datadogSubscription
+				if t.subscription == nil {
+					t.err = fmt.Errorf("datadog is not configured")
+				}
+				t.jobInfo.Tags = append(append([]string{}, jobInfo.Tags...), d.Datadog.Tags...)
+			}
+			t.name = t.sink + "@" + policy.Name + "/" + strconv.Itoa(i)
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// newPolicyMsTeamsV2 returns a Teams notification posting to the webhook URL
+// of the Secret, rendered with the configured template. Only Secrets labeled
+// with policySecretLabelName are read, so that a policy cannot make the
+// notifier send the value of any Secret of its namespace. Errors never hold
+// the value.
+func (c *Controller) newPolicyMsTeamsV2(namespace string, ref corev1.SecretKeySelector) (notification.Notification, error) {
+	secret, err := c.secretLister.Secrets(namespace).Get(ref.Name)
+	if err == nil && secret.Labels[policySecretLabelName] != "true" {
+		err = errors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+	}
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("secret %s/%s not found or not labeled %s=true", namespace, ref.Name, policySecretLabelName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook URL secret: %w", err)
+	}
+	webhookURL := string(secret.Data[ref.Key])
+	if webhookURL == "" {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", namespace, ref.Name, ref.Key)
+	}
+	config := notification.MsTeamsV2Config{Enabled: true, WebhookURL: webhookURL}
+	c.configMu.RLock()
+	if c.appliedConfig != nil {
+		config.Template = c.appliedConfig.MsTeamsV2.Template
+	}
+	c.configMu.RUnlock()
+	notifications, err := notification.NewNotifications(notification.Config{MsTeamsV2: config})
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s key %s holds an invalid webhook URL", namespace, ref.Name, ref.Key)
+	}
+	return notifications["msteamsv2"], nil
+}
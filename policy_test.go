@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubeinformers "k8s.io/client-go/informers"
+)
+
+func newTestPolicy(t *testing.T, name string, spec notificationPolicySpec) *unstructured.Unstructured {
+	t.Helper()
+	policy := &notificationPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kube-job-notifier.io/v1alpha1", Kind: "NotificationPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec:       spec,
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+// enableTestPolicies enables NotificationPolicies on the controller with a
+// fake dynamic client holding the policies.
+func enableTestPolicies(t *testing.T, c *Controller, policies ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	objects := make([]runtime.Object, 0, len(policies))
+	for _, policy := range policies {
+		objects = append(objects, policy)
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{policyGVR: "NotificationPolicyList"}, objects...)
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(client, 0).ForResource(policyGVR)
+	secretInformer := kubeinformers.NewSharedInformerFactoryWithOptions(c.kubeclientset, 0,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = policySecretLabelName + "=true"
+		})).Core().V1().Secrets()
+	c.enablePolicies(client, informer, secretInformer)
+	for _, policy := range policies {
+		if err := informer.Informer().GetIndexer().Add(policy); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Fill the Secret cache as the informer would.
+	secrets, err := c.kubeclientset.CoreV1().Secrets(metav1.NamespaceAll).List(context.TODO(),
+		metav1.ListOptions{LabelSelector: policySecretLabelName + "=true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range secrets.Items {
+		if err := secretInformer.Informer().GetIndexer().Add(&secrets.Items[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return client
+}
+
+func TestNotificationPolicyValidate(t *testing.T) {
+	slackDestinations := []policyDestination{{Slack: &slackDestination{Channel: "team-alerts"}}}
+	tests := []struct {
+		name     string
+		spec     notificationPolicySpec
+		expected string
+	}{
+		{"valid", notificationPolicySpec{
+			Events:             []string{"failure", "missed"},
+			Destinations:       slackDestinations,
+			SuppressionWindows: []suppressionWindow{{Start: "22:00", End: "06:00", Days: []string{"Sat", "sunday"}, TimeZone: "Asia/Tokyo"}},
+		}, ""},
+		{"no destinations", notificationPolicySpec{}, "at least one destination is required"},
+		{"unknown event", notificationPolicySpec{Events: []string{"finished"}, Destinations: slackDestinations}, `unknown event "finished"`},
+		{"selector", notificationPolicySpec{
+			Selector:     &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Equals"}}},
+			Destinations: slackDestinations,
+		}, "selector"},
+		{"two sinks", notificationPolicySpec{Destinations: []policyDestination{{
+			Slack:   &slackDestination{Channel: "team-alerts"},
+			Datadog: &datadogDestination{},
+		}}}, "destinations[0]: exactly one of"},
+		{"missing channel", notificationPolicySpec{Destinations: []policyDestination{{Slack: &slackDestination{}}}}, "slack.channel is required"},
+		{"missing secret key", notificationPolicySpec{Destinations: []policyDestination{{
+			MsTeamsV2: &msTeamsV2Destination{WebhookURLSecretRef: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "teams"}}},
+		}}}, "name and key are required"},
+		{"window", notificationPolicySpec{
+			Destinations:       slackDestinations,
+			SuppressionWindows: []suppressionWindow{{Start: "10pm", End: "06:00"}},
+		}, `suppressionWindows[0]: start: invalid time "10pm"`},
+		{"time zone", notificationPolicySpec{
+			Destinations:       slackDestinations,
+			SuppressionWindows: []suppressionWindow{{Start: "22:00", End: "06:00", TimeZone: "Mars/Olympus"}},
+		}, "suppressionWindows[0]: timeZone"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&notificationPolicy{Spec: test.spec}).validate()
+			if test.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+}
+
+func TestSuppressionWindowContains(t *testing.T) {
+	// 2024-06-01 is a Saturday.
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		window   suppressionWindow
+		t        time.Time
+		expected bool
+	}{
+		{"inside", suppressionWindow{Start: "09:00", End: "17:00"}, at(12, 0), true},
+		{"end is exclusive", suppressionWindow{Start: "09:00", End: "17:00"}, at(17, 0), false},
+		{"overnight before midnight", suppressionWindow{Start: "22:00", End: "06:00"}, at(23, 0), true},
+		{"overnight after midnight", suppressionWindow{Start: "22:00", End: "06:00"}, at(5, 59), true},
+		{"overnight outside", suppressionWindow{Start: "22:00", End: "06:00"}, at(12, 0), false},
+		{"day", suppressionWindow{Start: "00:00", End: "23:59", Days: []string{"Saturday"}}, at(12, 0), true},
+		{"other day", suppressionWindow{Start: "00:00", End: "23:59", Days: []string{"Sun"}}, at(12, 0), false},
+		{"overnight from previous day", suppressionWindow{Start: "22:00", End: "06:00", Days: []string{"Friday"}}, at(3, 0), true},
+		{"time zone", suppressionWindow{Start: "09:00", End: "17:00", TimeZone: "Asia/Tokyo"}, at(1, 0), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.window.contains(test.t))
+		})
+	}
+}
+
+func TestNotificationPolicyNotifies(t *testing.T) {
+	policy := &notificationPolicy{Spec: notificationPolicySpec{Events: []string{"failure", "retry"}}}
+	assert.True(t, policy.notifies(eventFailed))
+	assert.True(t, policy.notifies(eventRetry+"-2"))
+	assert.False(t, policy.notifies(eventStart))
+	assert.True(t, (&notificationPolicy{}).notifies(eventStart))
+}
+
+func TestNotifyWithPolicies(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-job",
+			Namespace: "default",
+			UID:       "test-uid",
+			Labels:    map[string]string{"team": "billing"},
+		},
+	}
+	billing := newTestPolicy(t, "billing", notificationPolicySpec{
+		Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"team": "billing"}},
+		Events:       []string{"start"},
+		Destinations: []policyDestination{{Slack: &slackDestination{Channel: "billing-alerts"}}},
+	})
+	invalid := newTestPolicy(t, "invalid", notificationPolicySpec{})
+	param := notification.MessageTemplateParam{JobName: job.Name}
+
+	t.Run("routes selected jobs to the policy destinations", func(t *testing.T) {
+		c, _ := newTestController(t, job)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"slack": n}
+		enableTestPolicies(t, c, billing, invalid)
+
+		err := c.notify(job, string(job.UID), eventStart, param, notification.Notification.NotifyStart, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n.started)
+		assert.Equal(t, "billing-alerts", n.lastStart.Annotations["kube-job-notifier/default-channel"])
+		assert.True(t, c.notifiedJobs.IsSent(string(job.UID), eventStart, "slack@billing/0"))
+
+		err = c.notify(job, string(job.UID), eventFailed, param, notification.Notification.NotifyFailed, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n.failed, "events not listed by the policy are not notified")
+	})
+
+	t.Run("routes other jobs to the configured sinks", func(t *testing.T) {
+		other := job.DeepCopy()
+		other.Labels = nil
+		c, _ := newTestController(t, other)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"slack": n}
+		enableTestPolicies(t, c, billing)
+
+		err := c.notify(other, string(other.UID), eventFailed, param, notification.Notification.NotifyFailed, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n.failed)
+		assert.True(t, c.notifiedJobs.IsSent(string(other.UID), eventFailed, "slack"))
+	})
+
+	t.Run("suppression window", func(t *testing.T) {
+		c, _ := newTestController(t, job)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"slack": n}
+		// The windows cover the whole day.
+		suppressed := newTestPolicy(t, "suppressed", notificationPolicySpec{
+			Destinations: []policyDestination{{Slack: &slackDestination{Channel: "billing-alerts"}}},
+			SuppressionWindows: []suppressionWindow{
+				{Start: "00:00", End: "23:59"},
+				{Start: "23:59", End: "00:00"},
+			},
+		})
+		enableTestPolicies(t, c, suppressed)
+
+		err := c.notify(job, string(job.UID), eventStart, param, notification.Notification.NotifyStart, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n.started)
+		assert.True(t, c.notifiedJobs.IsDone(string(job.UID), eventStart))
+	})
+
+	t.Run("teams webhook secret", func(t *testing.T) {
+		teams := newTestPolicy(t, "teams", notificationPolicySpec{
+			Destinations: []policyDestination{{MsTeamsV2: &msTeamsV2Destination{WebhookURLSecretRef: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "teams"},
+				Key:                  "webhookURL",
+			}}}},
+		})
+		c, _ := newTestController(t, job)
+		enableTestPolicies(t, c, teams)
+
+		targets := c.getTargets(job, eventFailed, param, monitoring.JobInfo{})
+		assert.Len(t, targets, 1)
+		assert.Equal(t, "msteamsv2@teams/0", targets[0].name)
+		assert.ErrorContains(t, targets[0].err, "secret default/teams not found or not labeled")
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "teams", Namespace: "default"},
+			Data:       map[string][]byte{"webhookURL": []byte("https://example.com/webhook/secret-token")},
+		}
+		c, _ = newTestController(t, job, secret)
+		enableTestPolicies(t, c, teams)
+
+		targets = c.getTargets(job, eventFailed, param, monitoring.JobInfo{})
+		assert.Len(t, targets, 1)
+		assert.ErrorContains(t, targets[0].err, "not labeled", "Secrets without the opt-in label are not read")
+
+		secret.Labels = map[string]string{policySecretLabelName: "true"}
+		c, _ = newTestController(t, job, secret)
+		enableTestPolicies(t, c, teams)
+
+		targets = c.getTargets(job, eventFailed, param, monitoring.JobInfo{})
+		assert.Len(t, targets, 1)
+		assert.NoError(t, targets[0].err)
+		assert.NotNil(t, targets[0].notification)
+	})
+
+	t.Run("teams destination with namespace webhook URL", func(t *testing.T) {
+		var policyCalls, namespaceCalls int
+		policyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policyCalls++
+		}))
+		defer policyServer.Close()
+		namespaceServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			namespaceCalls++
+		}))
+		defer namespaceServer.Close()
+
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{notification.MsTeamsV2WebhookURLAnnotationName: namespaceServer.URL},
+		}}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "teams", Namespace: "default",
+				Labels: map[string]string{policySecretLabelName: "true"}},
+			Data: map[string][]byte{"webhookURL": []byte(policyServer.URL)},
+		}
+		teams := newTestPolicy(t, "teams", notificationPolicySpec{
+			Destinations: []policyDestination{{MsTeamsV2: &msTeamsV2Destination{WebhookURLSecretRef: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "teams"},
+				Key:                  "webhookURL",
+			}}}},
+		})
+		c, _ := newTestController(t, job, namespace, secret)
+		enableTestPolicies(t, c, teams)
+		param := param
+		param.Annotations = c.getAnnotations(job, nil)
+		assert.Equal(t, namespaceServer.URL, param.Annotations[notification.MsTeamsV2WebhookURLAnnotationName])
+
+		err := c.notify(job, string(job.UID), eventFailed, param, notification.Notification.NotifyFailed, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, policyCalls)
+		assert.Equal(t, 0, namespaceCalls, "the namespace webhook URL does not override the policy destination")
+	})
+}
+
+func TestSyncPolicy(t *testing.T) {
+	valid := newTestPolicy(t, "valid", notificationPolicySpec{
+		Destinations: []policyDestination{{Datadog: &datadogDestination{Tags: []string{"team:billing"}}}},
+	})
+	invalid := newTestPolicy(t, "invalid", notificationPolicySpec{Events: []string{"finished"}})
+	c, _ := newTestController(t, nil)
+	client := enableTestPolicies(t, c, valid, invalid)
+
+	getReady := func(name string) *metav1.Condition {
+		t.Helper()
+		obj, err := client.Resource(policyGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		policy, err := toNotificationPolicy(obj)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), policy.Status.ObservedGeneration)
+		return meta.FindStatusCondition(policy.Status.Conditions, policyConditionReady)
+	}
+
+	assert.NoError(t, c.syncPolicy("default/valid"))
+	ready := getReady("valid")
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, policyReasonValid, ready.Reason)
+
+	assert.NoError(t, c.syncPolicy("default/invalid"))
+	ready = getReady("invalid")
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, policyReasonInvalid, ready.Reason)
+	assert.Contains(t, ready.Message, `unknown event "finished"`)
+
+	assert.NoError(t, c.syncPolicy("default/deleted"))
+}
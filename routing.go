@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thoas/go-funk"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// defaultGroupInterval is how long the notifications of a group are held
+// back after one was sent, when a route sets groupBy.
+const defaultGroupInterval = 5 * time.Minute
+
+// routeGroupFlushPeriod is how often the notifications held back by route
+// groups are checked for being due.
+const routeGroupFlushPeriod = 10 * time.Second
+
+// Group-by keys of the event attributes. Other keys are Job labels.
+const (
+	groupByNamespace = "namespace"
+	groupByCronJob   = "cronJob"
+	groupByJob       = "job"
+	groupByEvent     = "event"
+)
+
+// routeConfig is a node of the routing tree of the config file. As in
+// Alertmanager, an event enters the tree at the root and descends into the
+// first child route that matches it, and also into the following ones while
+// the matching route has continue set. It is sent to the receivers of the
+// deepest matching routes, or of the root if no child matches.
+type routeConfig struct {
+	// Receiver is the name of a receiver, or "slack" or "msteamsv2" for the
+	// top-level sinks. It is inherited from the parent route if empty.
+	Receiver string     `json:"receiver"`
+	Match    routeMatch `json:"match"`
+	// Continue keeps matching the following sibling routes after this one.
+	Continue bool `json:"continue"`
+	// GroupBy groups the events of the same type with the same values of
+	// these keys. The first event of a group is sent at once, and the events
+	// of the group during the following GroupInterval are sent together in
+	// one notification per receiver when it ends. Keys are namespace,
+	// cronJob, job, event or the name of a Job label. It is inherited from
+	// the parent route if empty.
+	GroupBy       []string        `json:"groupBy"`
+	GroupInterval metav1.Duration `json:"groupInterval"`
+	Routes        []routeConfig   `json:"routes"`
+}
+
+// routeMatch matches events by all of its fields that are set.
+type routeMatch struct {
+	Namespaces []string `json:"namespaces"`
+	// CronJobs are names of CronJobs. Jobs without a CronJob do not match.
+	CronJobs []string `json:"cronJobs"`
+	// Labels is a label selector of Job labels, e.g. "team=billing".
+	Labels string `json:"labels"`
+	// Events are event types as in NotificationPolicies, e.g. failure.
+	Events []string `json:"events"`
+}
+
+func (m routeMatch) isEmpty() bool {
+	return len(m.Namespaces) == 0 && len(m.CronJobs) == 0 && m.Labels == "" && len(m.Events) == 0
+}
+
+// route is a compiled routeConfig with the inherited fields resolved.
+type route struct {
+	// id is the path of the route in the tree, e.g. "0.2", so that groups
+	// of different routes do not fold each other.
+	id            string
+	receiver      string
+	namespaces    []string
+	cronJobs      []string
+	selector      labels.Selector
+	events        []string
+	cont          bool
+	groupBy       []string
+	groupInterval time.Duration
+	routes        []*route
+}
+
+// routeEvent is the event being routed.
+type routeEvent struct {
+	namespace string
+	cronJob   string
+	job       string
+	labels    map[string]string
+	event     string
+}
+
+// newRouteEvent returns the attributes of the event about obj, a Job or a
+// CronJob.
+func newRouteEvent(obj runtime.Object, event string, messageParam notification.MessageTemplateParam) routeEvent {
+	namespace, jobLabels := getJobLabels(obj)
+	return routeEvent{
+		namespace: namespace,
+		cronJob:   messageParam.CronJobName,
+		job:       messageParam.JobName,
+		labels:    jobLabels,
+		event:     event,
+	}
+}
+
+// newRoute compiles the root route and checks that it only references the
+// receivers.
+func newRoute(config routeConfig, receivers []string) (*route, error) {
+	if config.Receiver == "" {
+		return nil, fmt.Errorf("route.receiver is required")
+	}
+	if !config.Match.isEmpty() {
+		return nil, fmt.Errorf("route.match is not allowed on the root route, which matches every event")
+	}
+	return compileRoute(config, "route", "", &route{groupInterval: defaultGroupInterval}, receivers)
+}
+
+func compileRoute(config routeConfig, path, id string, parent *route, receivers []string) (*route, error) {
+	r := &route{
+		id:            id,
+		receiver:      config.Receiver,
+		namespaces:    config.Match.Namespaces,
+		cronJobs:      config.Match.CronJobs,
+		events:        config.Match.Events,
+		cont:          config.Continue,
+		groupBy:       config.GroupBy,
+		groupInterval: config.GroupInterval.Duration,
+	}
+	if r.receiver == "" {
+		r.receiver = parent.receiver
+	}
+	if !funk.ContainsString(receivers, r.receiver) {
+		return nil, fmt.Errorf("%s.receiver: unknown receiver %q", path, r.receiver)
+	}
+	if len(r.groupBy) == 0 {
+		r.groupBy = parent.groupBy
+	}
+	if r.groupInterval == 0 {
+		r.groupInterval = parent.groupInterval
+	}
+	if r.groupInterval < 0 {
+		return nil, fmt.Errorf("%s.groupInterval must not be negative", path)
+	}
+	selector, err := labels.Parse(config.Match.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("%s.match.labels: %w", path, err)
+	}
+	r.selector = selector
+	for _, event := range r.events {
+		if _, ok := policyEventTypes[event]; !ok {
+			return nil, fmt.Errorf("%s.match.events: unknown event %q", path, event)
+		}
+	}
+	for i, child := range config.Routes {
+		childID := strconv.Itoa(i)
+		if id != "" {
+			childID = id + "." + childID
+		}
+		c, err := compileRoute(child, fmt.Sprintf("%s.routes[%d]", path, i), childID, r, receivers)
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, c)
+	}
+	return r, nil
+}
+
+// matches reports whether the route itself matches the event.
+func (r *route) matches(e routeEvent) bool {
+	if len(r.namespaces) > 0 && !funk.ContainsString(r.namespaces, e.namespace) {
+		return false
+	}
+	if len(r.cronJobs) > 0 && !funk.ContainsString(r.cronJobs, e.cronJob) {
+		return false
+	}
+	if !r.selector.Matches(labels.Set(e.labels)) {
+		return false
+	}
+	return matchesEventTypes(r.events, e.event)
+}
+
+// match returns the routes the event is sent to, given that r matches it.
+func (r *route) match(e routeEvent) []*route {
+	var matched []*route
+	for _, child := range r.routes {
+		if !child.matches(e) {
+			continue
+		}
+		matched = append(matched, child.match(e)...)
+		if !child.cont {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return []*route{r}
+	}
+	return matched
+}
+
+// groupKey returns the key of the group of the event on the route, or an
+// empty string if the route does not group events. Groups are always split
+// by event.
+func (r *route) groupKey(e routeEvent) string {
+	if len(r.groupBy) == 0 {
+		return ""
+	}
+	values := make([]string, 0, len(r.groupBy))
+	for _, key := range r.groupBy {
+		var value string
+		switch key {
+		case groupByNamespace:
+			value = e.namespace
+		case groupByCronJob:
+			value = e.cronJob
+		case groupByJob:
+			value = e.job
+		case groupByEvent:
+			value = e.event
+		default:
+			value = e.labels[key]
+		}
+		values = append(values, key+"="+value)
+	}
+	// Events of different types are grouped apart, so that each
+	// notification has the title of its events.
+	if !funk.ContainsString(r.groupBy, groupByEvent) {
+		values = append(values, groupByEvent+"="+e.event)
+	}
+	return r.id + "/" + r.receiver + "/" + strings.Join(values, ",")
+}
+
+// maxGroupPending is the most notifications a route group holds back.
+// Further ones are dropped until the group sent its held back notifications.
+const maxGroupPending = 100
+
+// routeGroup is a group of a route that sent a notification less than its
+// interval ago.
+type routeGroup struct {
+	until    time.Time
+	interval time.Duration
+	// pending are the notifications held back since, oldest first.
+	pending []groupedNotification
+	// failures is the number of times sending the pending notifications
+	// failed in a row.
+	failures int
+}
+
+// groupedNotification is a notification held back by its route group.
+type groupedNotification struct {
+	obj    runtime.Object
+	uid    string
+	event  string
+	target target
+	notify func(notification.Notification, notification.MessageTemplateParam) (*notification.Delivery, error)
+}
+
+// routeGroups holds back the notifications of each group of a route while
+// the interval of the notification last sent for the group runs, so that
+// they are sent together when it ends, see Controller.flushRouteGroups.
+type routeGroups struct {
+	mu     sync.Mutex
+	groups map[string]*routeGroup
+}
+
+func newRouteGroups() *routeGroups {
+	return &routeGroups{groups: make(map[string]*routeGroup)}
+}
+
+// hold adds the notification to its group unless it is already held back,
+// and reports true if a notification was sent for the group less than its
+// interval ago. Otherwise the notification is to be sent at once. If the
+// group holds back maxGroupPending notifications, the notification is
+// dropped.
+func (g *routeGroups) hold(n groupedNotification, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group, ok := g.groups[n.target.group]
+	if !ok || !now.Before(group.until) {
+		return false
+	}
+	for _, p := range group.pending {
+		if p.uid == n.uid && p.event == n.event && p.target.name == n.target.name {
+			return true
+		}
+	}
+	if len(group.pending) >= maxGroupPending {
+		klog.Errorf("Dropped %s %s notification for job %s: group %s holds back %d notifications",
+			n.target.name, n.event, n.uid, n.target.group, maxGroupPending)
+		return true
+	}
+	group.pending = append(group.pending, n)
+	return true
+}
+
+// markSent holds back the notifications of the group until the interval
+// passed.
+func (g *routeGroups) markSent(key string, now time.Time, interval time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group, ok := g.groups[key]
+	if !ok {
+		group = &routeGroup{}
+		g.groups[key] = group
+	}
+	group.until = now.Add(interval)
+	group.interval = interval
+}
+
+// due returns the notifications held back by each group whose interval
+// passed, and forgets the groups that held back none. A group that returns
+// notifications holds back the following ones for another interval, so that
+// it sends at most one notification per interval. The notifications stay
+// held back until passed to sent or failed.
+func (g *routeGroups) due(now time.Time) [][]groupedNotification {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var batches [][]groupedNotification
+	for _, key := range slices.Sorted(maps.Keys(g.groups)) {
+		group := g.groups[key]
+		if now.Before(group.until) {
+			continue
+		}
+		if len(group.pending) == 0 {
+			delete(g.groups, key)
+			continue
+		}
+		batches = append(batches, slices.Clone(group.pending))
+		group.until = now.Add(group.interval)
+	}
+	return batches
+}
+
+// sent removes a batch returned by due from its group after it was sent.
+func (g *routeGroups) sent(batch []groupedNotification) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if group, ok := g.groups[batch[0].target.group]; ok {
+		group.pending = group.pending[len(batch):]
+		group.failures = 0
+	}
+}
+
+// failed records that sending a batch returned by due failed, so that it is
+// retried after the interval of its group. After maxRetries failures in a
+// row the batch is dropped, and failed reports true.
+func (g *routeGroups) failed(batch []groupedNotification) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group, ok := g.groups[batch[0].target.group]
+	if !ok {
+		return true
+	}
+	group.failures++
+	if group.failures < maxRetries {
+		return false
+	}
+	group.pending = group.pending[len(batch):]
+	group.failures = 0
+	return true
+}
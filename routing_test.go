@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const testRouteConfig = `
+receiver: slack
+groupBy: [cronJob]
+routes:
+  - match:
+      namespaces: [billing]
+    receiver: billing
+    continue: true
+    routes:
+      - match:
+          events: [failure]
+        receiver: billing-oncall
+  - match:
+      labels: team=search
+    receiver: search
+  - match:
+      cronJobs: [nightly-report]
+    receiver: reports
+`
+
+func newTestRoute(t *testing.T) *route {
+	t.Helper()
+	var config routeConfig
+	if err := yaml.UnmarshalStrict([]byte(testRouteConfig), &config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := newRoute(config, []string{"slack", "billing", "billing-oncall", "search", "reports"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return r
+}
+
+func TestRouteMatch(t *testing.T) {
+	root := newTestRoute(t)
+	tests := []struct {
+		name     string
+		event    routeEvent
+		expected []string
+	}{
+		{"no match", routeEvent{namespace: "default", event: eventSuccess}, []string{"slack"}},
+		{"namespace", routeEvent{namespace: "billing", event: eventSuccess}, []string{"billing"}},
+		{"nested", routeEvent{namespace: "billing", event: eventFailed}, []string{"billing-oncall"}},
+		{"continue", routeEvent{namespace: "billing", labels: map[string]string{"team": "search"}, event: eventSuccess},
+			[]string{"billing", "search"}},
+		{"first match without continue", routeEvent{labels: map[string]string{"team": "search"}, cronJob: "nightly-report"},
+			[]string{"search"}},
+		{"cronjob", routeEvent{cronJob: "nightly-report"}, []string{"reports"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var receivers []string
+			for _, r := range root.match(test.event) {
+				receivers = append(receivers, r.receiver)
+			}
+			assert.Equal(t, test.expected, receivers)
+		})
+	}
+}
+
+func TestRouteGroupKey(t *testing.T) {
+	root := newTestRoute(t)
+	billing := root.routes[0]
+	assert.Equal(t, "0/billing/cronJob=nightly,event=failed",
+		billing.groupKey(routeEvent{cronJob: "nightly", job: "nightly-1", event: eventFailed}))
+	assert.Equal(t, "0.0/billing-oncall/cronJob=nightly,event=start",
+		billing.routes[0].groupKey(routeEvent{cronJob: "nightly", event: eventStart}))
+	assert.Equal(t, defaultGroupInterval, billing.groupInterval)
+
+	byEvent, err := newRoute(routeConfig{Receiver: "slack", GroupBy: []string{"event", "team"}}, []string{"slack"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/slack/event=failed,team=billing",
+		byEvent.groupKey(routeEvent{labels: map[string]string{"team": "billing"}, event: eventFailed}))
+
+	ungrouped, err := newRoute(routeConfig{Receiver: "slack"}, []string{"slack"})
+	assert.NoError(t, err)
+	assert.Empty(t, ungrouped.groupKey(routeEvent{cronJob: "nightly"}))
+}
+
+func TestNewRouteInvalid(t *testing.T) {
+	receivers := []string{"slack"}
+	tests := []struct {
+		name     string
+		config   routeConfig
+		expected string
+	}{
+		{"no receiver", routeConfig{}, "route.receiver is required"},
+		{"root match", routeConfig{Receiver: "slack", Match: routeMatch{Namespaces: []string{"a"}}}, "not allowed on the root route"},
+		{"unknown receiver", routeConfig{Receiver: "slack", Routes: []routeConfig{{Receiver: "teams"}}},
+			`route.routes[0].receiver: unknown receiver "teams"`},
+		{"labels", routeConfig{Receiver: "slack", Routes: []routeConfig{{Match: routeMatch{Labels: "team in billing"}}}},
+			"route.routes[0].match.labels"},
+		{"events", routeConfig{Receiver: "slack", Routes: []routeConfig{{Match: routeMatch{Events: []string{"finished"}}}}},
+			`unknown event "finished"`},
+		{"group interval", routeConfig{Receiver: "slack", GroupInterval: metav1.Duration{Duration: -time.Minute}},
+			"route.groupInterval must not be negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newRoute(test.config, receivers)
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+}
+
+func TestNotifyWithRoutes(t *testing.T) {
+	newJob := func(name string) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "billing",
+			UID:       types.UID("uid-" + name),
+		}}
+	}
+	jobs := []*batchv1.Job{newJob("nightly-1"), newJob("nightly-2"), newJob("nightly-3")}
+	c, _ := newTestController(t, jobs[0], jobs[1], jobs[2])
+	slack, billing, oncall := &fakeNotification{}, &fakeNotification{}, &fakeNotification{}
+	c.notifications = map[string]notification.Notification{"slack": slack, "billing": billing, "billing-oncall": oncall}
+	c.route = newTestRoute(t)
+
+	now := time.Now()
+	notifyFailed := func(job *batchv1.Job) {
+		t.Helper()
+		param := notification.MessageTemplateParam{JobName: job.Name, CronJobName: "nightly", Namespace: job.Namespace}
+		err := c.notify(job, string(job.UID), eventFailed, param, notification.Notification.NotifyFailed, monitoring.JobInfo{}, nil)
+		assert.NoError(t, err)
+	}
+	for _, job := range jobs {
+		notifyFailed(job)
+	}
+	notifyFailed(jobs[1])
+	assert.Equal(t, 0, slack.failed)
+	assert.Equal(t, 0, billing.failed)
+	assert.Equal(t, 1, oncall.failed, "the following failures of the CronJob are held back")
+	assert.True(t, c.notifiedJobs.IsDone("uid-nightly-1", eventFailed))
+	assert.False(t, c.notifiedJobs.IsDone("uid-nightly-2", eventFailed), "held back events are done once sent")
+
+	c.flushRouteGroups(now)
+	assert.Equal(t, 1, oncall.failed)
+
+	oncall.err = errors.New("unavailable")
+	c.flushRouteGroups(now.Add(defaultGroupInterval + time.Second))
+	assert.Equal(t, 2, oncall.failed)
+	oncall.err = nil
+	c.flushRouteGroups(now.Add(defaultGroupInterval + 2*time.Second))
+	assert.Equal(t, 2, oncall.failed, "a failed batch is retried after another interval")
+
+	c.flushRouteGroups(now.Add(2*defaultGroupInterval + 2*time.Second))
+	assert.Equal(t, 3, oncall.failed)
+	assert.Equal(t, "nightly-3", oncall.lastFailed.JobName)
+	assert.Equal(t, []notification.GroupedEvent{
+		{Event: eventFailed, Namespace: "billing", CronJobName: "nightly", JobName: "nightly-2"},
+	}, oncall.lastFailed.Grouped)
+	assert.Equal(t, 2, c.workqueue.Len(), "the Jobs of the sent events are requeued")
+
+	notifyFailed(jobs[1])
+	assert.True(t, c.notifiedJobs.IsDone("uid-nightly-2", eventFailed))
+	assert.Equal(t, 3, oncall.failed)
+
+	c.flushRouteGroups(now.Add(3*defaultGroupInterval + 3*time.Second))
+	assert.Equal(t, 3, oncall.failed)
+	assert.Empty(t, c.routeGroups.groups, "groups without held back notifications are forgotten")
+}
+
+func TestRouteGroupsLimits(t *testing.T) {
+	now := time.Now()
+	g := newRouteGroups()
+	g.markSent("group", now, time.Minute)
+	for i := 0; i < maxGroupPending+1; i++ {
+		n := groupedNotification{uid: strconv.Itoa(i), event: eventFailed, target: target{name: "slack", group: "group"}}
+		assert.True(t, g.hold(n, now))
+	}
+	assert.Len(t, g.groups["group"].pending, maxGroupPending, "notifications beyond the limit are dropped")
+
+	for i := 1; i < maxRetries; i++ {
+		batch := g.due(now.Add(time.Duration(i) * time.Minute))
+		assert.Len(t, batch, 1)
+		assert.False(t, g.failed(batch[0]))
+	}
+	batch := g.due(now.Add(maxRetries * time.Minute))
+	assert.True(t, g.failed(batch[0]), "a batch is dropped after maxRetries failures")
+	assert.Empty(t, g.groups["group"].pending)
+}
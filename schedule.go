@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/yutachaos/kube-job-notifier/pkg/monitoring"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const eventMissedSchedule = "missed-schedule"
+
+// checkMissedSchedules notifies CronJobs whose next run, computed from
+// spec.schedule and status.lastScheduleTime, is overdue by more than the
+// grace period. Each missed run is notified once, also across restarts; when
+// the CronJob runs again the Datadog service check is recovered. CronJobs
+// whose Jobs would not be notified by the filters are not checked.
+func (c *Controller) checkMissedSchedules() {
+	cronJobs, err := c.cronJobLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List cronjobs failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[types.UID]bool, len(cronJobs))
+	for _, cronJob := range cronJobs {
+		job := newJobFromTemplate(cronJob)
+		if !c.isNamespaceWatched(cronJob.Namespace) || !c.isOptedIn(c.getAnnotations(job, cronJob)) ||
+			!c.isCronJobSelected(job, cronJob) {
+			continue
+		}
+		seen[cronJob.UID] = true
+
+		expected, err := getMissedScheduleTime(cronJob, now, c.config.missedScheduleGracePeriod)
+		if err != nil {
+			klog.Errorf("CronJob %s/%s: Invalid schedule: %v", cronJob.Namespace, cronJob.Name, err)
+			continue
+		}
+
+		if expected.IsZero() {
+			c.resumeSchedule(cronJob)
+			continue
+		}
+
+		id := fmt.Sprintf("%s/%d", cronJob.UID, expected.Unix())
+		if previous, ok := c.missedSchedules[cronJob.UID]; ok && previous != id {
+			c.notifiedJobs.Delete(previous)
+		}
+		c.missedSchedules[cronJob.UID] = id
+		if !c.notifiedJobs.IsDone(id, eventMissedSchedule) &&
+			cronJob.Annotations[missedScheduleAnnotationName] == expected.UTC().Format(time.RFC3339) {
+			// Notified before a restart. Datadog, if it is configured, got
+			// it as well, so that it is recovered on resume.
+			if _, datadogSubscription := c.getSinks(); datadogSubscription != nil {
+				c.notifiedJobs.MarkSent(id, eventMissedSchedule, datadogSinkName)
+			}
+			c.notifiedJobs.MarkDone(id, eventMissedSchedule)
+		}
+		if c.notifiedJobs.IsDone(id, eventMissedSchedule) {
+			continue
+		}
+
+		klog.Infof("CronJob %s/%s: Missed schedule at %v", cronJob.Namespace, cronJob.Name, expected)
+		messageParam := c.newMessageTemplateParam(job, cronJob.Name, cronJob)
+		messageParam.LastScheduleTime = cronJob.Status.LastScheduleTime
+		messageParam.ExpectedScheduleTime = &metav1.Time{Time: expected}
+		jobInfo := monitoring.JobInfo{
+			ClusterName: c.config.clusterName,
+			CronJobName: cronJob.Name,
+			Namespace:   cronJob.Namespace,
+			Annotations: messageParam.Annotations,
+		}
+		err = c.notify(cronJob, id, eventMissedSchedule, messageParam, notification.Notification.NotifyMissedSchedule,
+			jobInfo, monitoring.Subscription.ScheduleMissedEvent)
+		if err != nil {
+			klog.Errorf("CronJob %s/%s: Missed schedule notification failed, retrying on next check: %v",
+				cronJob.Namespace, cronJob.Name, err)
+			continue
+		}
+		// Notifications held back by a route group are done once sent.
+		if !c.notifiedJobs.IsDone(id, eventMissedSchedule) {
+			continue
+		}
+		if err = c.persistMissedSchedule(cronJob, expected); err != nil {
+			klog.Errorf("CronJob %s/%s: %v", cronJob.Namespace, cronJob.Name, err)
+		}
+	}
+
+	for uid, id := range c.missedSchedules {
+		if !seen[uid] {
+			delete(c.missedSchedules, uid)
+			c.notifiedJobs.Delete(id)
+		}
+	}
+}
+
+// persistMissedSchedule writes the scheduled time of the notified missed run
+// to the CronJob annotations, so that it is not notified again after a
+// restart.
+func (c *Controller) persistMissedSchedule(cronJob *batchv1.CronJob, expected time.Time) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				missedScheduleAnnotationName: expected.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeclientset.BatchV1().CronJobs(cronJob.Namespace).Patch(context.TODO(), cronJob.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record missed schedule on cronjob: %w", err)
+	}
+	return nil
+}
+
+// isCronJobSelected reports whether the Jobs of the CronJob pass the
+// filters, judged by job, the Job its template creates: the CronJob name
+// regex, the Job label selector and the CEL filter.
+func (c *Controller) isCronJobSelected(job *batchv1.Job, cronJob *batchv1.CronJob) bool {
+	regex, filter := c.getFilters()
+	if regex != nil && !regex.MatchString(cronJob.Name) {
+		return false
+	}
+	if c.config.jobLabelSelector != nil && !c.config.jobLabelSelector.Matches(labels.Set(job.Labels)) {
+		return false
+	}
+	if filter == nil {
+		return true
+	}
+	matched, err := filter.Matches(job, cronJob)
+	if err != nil {
+		klog.Warningf("CronJob %s/%s: Not checked, JOB_CEL_FILTER failed: %v", cronJob.Namespace, cronJob.Name, err)
+	}
+	return matched
+}
+
+// resumeSchedule forgets the missed schedule of a CronJob that runs on
+// schedule again, and recovers its Datadog service check.
+func (c *Controller) resumeSchedule(cronJob *batchv1.CronJob) {
+	id, ok := c.missedSchedules[cronJob.UID]
+	if !ok {
+		return
+	}
+	// The subscription is nil if Datadog was disabled by a config reload.
+	_, datadogSubscription := c.getSinks()
+	if datadogSubscription != nil && c.notifiedJobs.IsSent(id, eventMissedSchedule, datadogSinkName) {
+		jobInfo := monitoring.JobInfo{
+			ClusterName: c.config.clusterName,
+			CronJobName: cronJob.Name,
+			Namespace:   cronJob.Namespace,
+		}
+		if err := datadogSubscription.ScheduleResumedEvent(jobInfo); err != nil {
+			klog.Errorf("Fail event subscribe.: %v", err)
+			return
+		}
+	}
+	klog.Infof("CronJob %s/%s: Resumed schedule", cronJob.Namespace, cronJob.Name)
+	delete(c.missedSchedules, cronJob.UID)
+	c.notifiedJobs.Delete(id)
+}
+
+// getMissedScheduleTime returns the scheduled time of the first run after
+// the last one that is overdue by more than gracePeriod, or the zero time if
+// the CronJob is on schedule.
+func getMissedScheduleTime(cronJob *batchv1.CronJob, now time.Time, gracePeriod time.Duration) (time.Time, error) {
+	schedule, err := cron.ParseStandard(cronJob.Spec.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	location := time.UTC
+	if cronJob.Spec.TimeZone != nil {
+		location, err = time.LoadLocation(*cronJob.Spec.TimeZone)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	last := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastScheduleTime != nil {
+		last = cronJob.Status.LastScheduleTime.Time
+	}
+
+	next := schedule.Next(last.In(location))
+	if next.IsZero() || !now.After(next.Add(gracePeriod)) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yutachaos/kube-job-notifier/pkg/notification"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilpointer "k8s.io/utils/pointer"
+)
+
+func TestGetMissedScheduleTime(t *testing.T) {
+	now := time.Date(2020, 11, 28, 1, 2, 3, 0, time.UTC)
+	tests := []struct {
+		name             string
+		schedule         string
+		timeZone         *string
+		lastScheduleTime time.Time
+		expected         time.Time
+		expectErr        bool
+	}{
+		{
+			name:             "on schedule",
+			schedule:         "0 * * * *",
+			lastScheduleTime: time.Date(2020, 11, 28, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:             "within grace period",
+			schedule:         "0 * * * *",
+			lastScheduleTime: time.Date(2020, 11, 28, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:             "missed",
+			schedule:         "*/30 * * * *",
+			lastScheduleTime: time.Date(2020, 11, 28, 0, 0, 0, 0, time.UTC),
+			expected:         time.Date(2020, 11, 28, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:             "missed in time zone",
+			schedule:         "30 9 * * *",
+			timeZone:         utilpointer.String("Asia/Tokyo"),
+			lastScheduleTime: time.Date(2020, 11, 26, 0, 30, 0, 0, time.UTC),
+			expected:         time.Date(2020, 11, 27, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:      "invalid schedule",
+			schedule:  "invalid",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cronJob := &batchv1.CronJob{
+				Spec: batchv1.CronJobSpec{Schedule: test.schedule, TimeZone: test.timeZone},
+				Status: batchv1.CronJobStatus{
+					LastScheduleTime: &metav1.Time{Time: test.lastScheduleTime},
+				},
+			}
+			got, err := getMissedScheduleTime(cronJob, now, 5*time.Minute)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, test.expected.Equal(got), "expected %v, got %v", test.expected, got)
+		})
+	}
+}
+
+func TestCheckMissedSchedules(t *testing.T) {
+	lastScheduleTime := metav1.NewTime(time.Now().Add(-2 * time.Hour).Truncate(time.Hour))
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cronjob", Namespace: "default", UID: "cronjob-uid"},
+		Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *"},
+		Status:     batchv1.CronJobStatus{LastScheduleTime: &lastScheduleTime},
+	}
+
+	expected := lastScheduleTime.Add(time.Hour).UTC().Format(time.RFC3339)
+
+	t.Run("notifies a missed schedule once", func(t *testing.T) {
+		c, client := newTestController(t, nil, cronJob)
+		c.config.missedScheduleGracePeriod = 5 * time.Minute
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		c.checkMissedSchedules()
+		c.checkMissedSchedules()
+		assert.Equal(t, 1, n.missed)
+		assert.Contains(t, c.missedSchedules, cronJob.UID)
+
+		updated, err := client.BatchV1().CronJobs("default").Get(context.TODO(), cronJob.Name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, updated.Annotations[missedScheduleAnnotationName])
+	})
+
+	t.Run("does not notify a missed schedule again after a restart", func(t *testing.T) {
+		notified := cronJob.DeepCopy()
+		notified.Annotations = map[string]string{missedScheduleAnnotationName: expected}
+		c, _ := newTestController(t, nil, notified)
+		c.config.missedScheduleGracePeriod = 5 * time.Minute
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		c.checkMissedSchedules()
+		assert.Equal(t, 0, n.missed)
+		assert.Contains(t, c.missedSchedules, cronJob.UID)
+	})
+
+	t.Run("skips CronJobs whose Jobs are filtered out", func(t *testing.T) {
+		labeled := cronJob.DeepCopy()
+		labeled.Spec.JobTemplate.Labels = map[string]string{"team": "search"}
+		billing, err := newJobFilter(`has(job.metadata.labels) && job.metadata.labels.team == "billing"`)
+		assert.NoError(t, err)
+		search, err := newJobFilter(`job.metadata.labels.team == "search"`)
+		assert.NoError(t, err)
+
+		tests := []struct {
+			name     string
+			selector string
+			filter   *jobFilter
+			expected int
+		}{
+			{"label selector", "team=billing", nil, 0},
+			{"cel filter", "", billing, 0},
+			{"matching filters", "team=search", search, 1},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				c, _ := newTestController(t, nil, labeled)
+				c.config.missedScheduleGracePeriod = 5 * time.Minute
+				selector, err := labels.Parse(test.selector)
+				assert.NoError(t, err)
+				c.config.jobLabelSelector = selector
+				c.filter = test.filter
+				n := &fakeNotification{}
+				c.notifications = map[string]notification.Notification{"fake": n}
+
+				c.checkMissedSchedules()
+				assert.Equal(t, test.expected, n.missed)
+			})
+		}
+	})
+
+	t.Run("forgets the missed schedule once the CronJob runs again", func(t *testing.T) {
+		resumed := cronJob.DeepCopy()
+		resumed.Status.LastScheduleTime = &metav1.Time{Time: time.Now()}
+		c, _ := newTestController(t, nil, resumed)
+		c.config.missedScheduleGracePeriod = 5 * time.Minute
+		c.missedSchedules[cronJob.UID] = "cronjob-uid/0"
+		c.notifiedJobs.MarkDone("cronjob-uid/0", eventMissedSchedule)
+		n := &fakeNotification{}
+		c.notifications = map[string]notification.Notification{"fake": n}
+
+		c.checkMissedSchedules()
+		assert.Equal(t, 0, n.missed)
+		assert.NotContains(t, c.missedSchedules, cronJob.UID)
+		assert.False(t, c.notifiedJobs.IsDone("cronjob-uid/0", eventMissedSchedule))
+	})
+}